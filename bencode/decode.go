@@ -0,0 +1,536 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Reasons carried by a SyntaxError. Use errors.Is to test for them.
+var (
+	ErrUnexpectedEnd       = errors.New("unexpected end of input")
+	ErrInvalidType         = errors.New("invalid value type")
+	ErrInvalidInteger      = errors.New("malformed integer")
+	ErrLeadingZero         = errors.New("leading zero in number")
+	ErrNegativeZero        = errors.New("negative zero")
+	ErrIntegerOverflow     = errors.New("integer overflows int64")
+	ErrInvalidStringLength = errors.New("malformed string length")
+	ErrNonStringKey        = errors.New("dictionary key is not a string")
+	ErrUnsortedKeys        = errors.New("dictionary keys are not sorted")
+	ErrDuplicateKey        = errors.New("duplicate dictionary key")
+	ErrTrailingData        = errors.New("trailing data after value")
+	ErrMaxDepth            = errors.New("exceeded maximum nesting depth")
+)
+
+const maxDepth = 1000
+
+// SyntaxError describes input that is not canonical bencode (BEP 3).
+type SyntaxError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("bencode: %s at offset %d", e.Err, e.Offset)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// UnmarshalTypeError describes a bencode value that can't be stored in a Go value.
+type UnmarshalTypeError struct {
+	Value  string
+	Type   reflect.Type
+	Offset int64
+	Field  string
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("bencode: cannot unmarshal %s into field %s of type %s at offset %d", e.Value, e.Field, e.Type, e.Offset)
+	}
+	return fmt.Sprintf("bencode: cannot unmarshal %s into Go value of type %s at offset %d", e.Value, e.Type, e.Offset)
+}
+
+// UnmarshalerError wraps an error returned by an Unmarshaler.
+type UnmarshalerError struct {
+	Type   reflect.Type
+	Offset int64
+	Field  string
+	Err    error
+}
+
+func (e *UnmarshalerError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("bencode: field %s (%s) at offset %d: %s", e.Field, e.Type, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("bencode: %s at offset %d: %s", e.Type, e.Offset, e.Err)
+}
+
+func (e *UnmarshalerError) Unwrap() error {
+	return e.Err
+}
+
+// Decode parses a single bencoded value. Integers become int64, byte strings
+// string, lists []interface{} and dictionaries map[string]interface{}.
+func Decode(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Unmarshal parses data and stores the result in the value pointed to by v.
+// Struct fields are matched against the `bencode:"name"` tag or, failing that,
+// case-insensitively against the field name.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("bencode: Unmarshal requires a non-nil pointer, got %T", v)
+	}
+	d := &decodeState{data: data}
+	if err := d.value(rv); err != nil {
+		return err
+	}
+	if d.off != len(d.data) {
+		return d.syntaxError(ErrTrailingData)
+	}
+	return nil
+}
+
+type decodeState struct {
+	data  []byte
+	off   int
+	depth int
+	field string
+}
+
+func (d *decodeState) syntaxError(reason error) error {
+	return &SyntaxError{Offset: int64(d.off), Err: reason}
+}
+
+func (d *decodeState) typeError(kind string, t reflect.Type, off int) error {
+	return &UnmarshalTypeError{Value: kind, Type: t, Offset: int64(off), Field: d.field}
+}
+
+// value decodes the next value into v. An invalid v skips the value while
+// still validating it.
+func (d *decodeState) value(v reflect.Value) error {
+	if d.off >= len(d.data) {
+		return d.syntaxError(ErrUnexpectedEnd)
+	}
+	if v.IsValid() {
+		v = indirect(v)
+		if v.Type() == rawMessageType {
+			start := d.off
+			if err := d.value(reflect.Value{}); err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte(nil), d.data[start:d.off]...))
+			return nil
+		}
+		if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+			start := d.off
+			if err := d.value(reflect.Value{}); err != nil {
+				return err
+			}
+			if err := v.Addr().Interface().(Unmarshaler).UnmarshalBencode(d.data[start:d.off]); err != nil {
+				return &UnmarshalerError{Type: v.Type(), Offset: int64(start), Field: d.field, Err: err}
+			}
+			return nil
+		}
+	}
+	switch c := d.data[d.off]; {
+	case c == 'i':
+		return d.integer(v)
+	case c >= '0' && c <= '9':
+		return d.byteString(v)
+	case c == 'l':
+		return d.list(v)
+	case c == 'd':
+		return d.dict(v)
+	default:
+		return d.syntaxError(ErrInvalidType)
+	}
+}
+
+// indirect allocates nil pointers and walks down to the value to be set.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func isEmptyInterface(v reflect.Value) bool {
+	return v.Kind() == reflect.Interface && v.NumMethod() == 0
+}
+
+// parseInt reads the digits of an integer starting at d.off up to the
+// terminator byte and enforces canonical form.
+func (d *decodeState) parseInt(terminator byte, allowSign bool) (int64, error) {
+	start := d.off
+	if allowSign && d.off < len(d.data) && d.data[d.off] == '-' {
+		d.off++
+	}
+	for d.off < len(d.data) && d.data[d.off] >= '0' && d.data[d.off] <= '9' {
+		d.off++
+	}
+	if d.off >= len(d.data) {
+		return 0, d.syntaxError(ErrUnexpectedEnd)
+	}
+	if d.data[d.off] != terminator {
+		return 0, d.syntaxError(malformed(terminator))
+	}
+	n, pos, err := parseCanonicalInt(d.data[start:d.off], terminator)
+	if err != nil {
+		return 0, &SyntaxError{Offset: int64(start + pos), Err: err}
+	}
+	d.off++ // terminator
+	return n, nil
+}
+
+func malformed(terminator byte) error {
+	if terminator == ':' {
+		return ErrInvalidStringLength
+	}
+	return ErrInvalidInteger
+}
+
+// parseCanonicalInt parses an optionally signed run of digits, rejecting
+// empty input, leading zeros and negative zero. On failure it also returns
+// the position within b of the offending byte.
+func parseCanonicalInt(b []byte, terminator byte) (int64, int, error) {
+	digits := 0
+	if len(b) > 0 && b[0] == '-' {
+		digits = 1
+	}
+	if digits == len(b) {
+		return 0, len(b), malformed(terminator)
+	}
+	if b[digits] == '0' && len(b)-digits > 1 {
+		return 0, digits, ErrLeadingZero
+	}
+	if digits > 0 && b[digits] == '0' {
+		return 0, 0, ErrNegativeZero
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, 0, ErrIntegerOverflow
+	}
+	return n, 0, nil
+}
+
+func (d *decodeState) integer(v reflect.Value) error {
+	start := d.off
+	d.off++ // 'i'
+	n, err := d.parseInt('e', true)
+	if err != nil {
+		return err
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(n) {
+			return d.typeError("integer "+strconv.FormatInt(n, 10), v.Type(), start)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return d.typeError("integer "+strconv.FormatInt(n, 10), v.Type(), start)
+		}
+		v.SetUint(uint64(n))
+	case reflect.Bool:
+		v.SetBool(n != 0)
+	case reflect.Interface:
+		if !isEmptyInterface(v) {
+			return d.typeError("integer", v.Type(), start)
+		}
+		v.Set(reflect.ValueOf(n))
+	default:
+		return d.typeError("integer", v.Type(), start)
+	}
+	return nil
+}
+
+func (d *decodeState) readBytes() ([]byte, error) {
+	n, err := d.parseInt(':', false)
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(len(d.data)-d.off) {
+		return nil, d.syntaxError(ErrUnexpectedEnd)
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+func (d *decodeState) byteString(v reflect.Value) error {
+	start := d.off
+	b, err := d.readBytes()
+	if err != nil {
+		return err
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return d.typeError("string", v.Type(), start)
+		}
+		v.SetBytes(append([]byte(nil), b...))
+	case reflect.Array:
+		if v.Type().Elem().Kind() != reflect.Uint8 || v.Len() != len(b) {
+			return d.typeError("string", v.Type(), start)
+		}
+		reflect.Copy(v, reflect.ValueOf(b))
+	case reflect.Interface:
+		if !isEmptyInterface(v) {
+			return d.typeError("string", v.Type(), start)
+		}
+		v.Set(reflect.ValueOf(string(b)))
+	default:
+		return d.typeError("string", v.Type(), start)
+	}
+	return nil
+}
+
+func (d *decodeState) enter() error {
+	d.depth++
+	if d.depth > maxDepth {
+		return d.syntaxError(ErrMaxDepth)
+	}
+	return nil
+}
+
+func (d *decodeState) list(v reflect.Value) error {
+	start := d.off
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer func() { d.depth-- }()
+	d.off++ // 'l'
+
+	var generic []interface{}
+	if v.IsValid() {
+		switch v.Kind() {
+		case reflect.Slice:
+			v.SetLen(0)
+		case reflect.Array:
+		case reflect.Interface:
+			if !isEmptyInterface(v) {
+				return d.typeError("list", v.Type(), start)
+			}
+			generic = make([]interface{}, 0)
+		default:
+			return d.typeError("list", v.Type(), start)
+		}
+	}
+
+	for i := 0; ; i++ {
+		if d.off >= len(d.data) {
+			return d.syntaxError(ErrUnexpectedEnd)
+		}
+		if d.data[d.off] == 'e' {
+			d.off++
+			break
+		}
+		var elem reflect.Value
+		switch {
+		case !v.IsValid():
+		case generic != nil:
+			var e interface{}
+			elem = reflect.ValueOf(&e).Elem()
+			if err := d.value(elem); err != nil {
+				return err
+			}
+			generic = append(generic, e)
+			continue
+		case v.Kind() == reflect.Slice:
+			v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+			elem = v.Index(i)
+		case i < v.Len():
+			elem = v.Index(i)
+		}
+		if err := d.value(elem); err != nil {
+			return err
+		}
+	}
+
+	if generic != nil {
+		v.Set(reflect.ValueOf(generic))
+	} else if v.IsValid() && v.Kind() == reflect.Slice && v.IsNil() {
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	}
+	return nil
+}
+
+func (d *decodeState) dict(v reflect.Value) error {
+	start := d.off
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer func() { d.depth-- }()
+	d.off++ // 'd'
+
+	var fields []field
+	var generic map[string]interface{}
+	if v.IsValid() {
+		switch v.Kind() {
+		case reflect.Struct:
+			fields = cachedFields(v.Type())
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return d.typeError("dictionary", v.Type(), start)
+			}
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+		case reflect.Interface:
+			if !isEmptyInterface(v) {
+				return d.typeError("dictionary", v.Type(), start)
+			}
+			generic = make(map[string]interface{})
+		default:
+			return d.typeError("dictionary", v.Type(), start)
+		}
+	}
+
+	var prevKey []byte
+	for first := true; ; first = false {
+		if d.off >= len(d.data) {
+			return d.syntaxError(ErrUnexpectedEnd)
+		}
+		if d.data[d.off] == 'e' {
+			d.off++
+			break
+		}
+		if c := d.data[d.off]; c < '0' || c > '9' {
+			return d.syntaxError(ErrNonStringKey)
+		}
+		keyStart := d.off
+		key, err := d.readBytes()
+		if err != nil {
+			return err
+		}
+		if !first {
+			switch cmp := bytes.Compare(prevKey, key); {
+			case cmp == 0:
+				return &SyntaxError{Offset: int64(keyStart), Err: ErrDuplicateKey}
+			case cmp > 0:
+				return &SyntaxError{Offset: int64(keyStart), Err: ErrUnsortedKeys}
+			}
+		}
+		prevKey = key
+
+		parentField := d.field
+		d.field = joinField(parentField, string(key))
+		switch {
+		case !v.IsValid():
+			err = d.value(reflect.Value{})
+		case generic != nil:
+			var e interface{}
+			err = d.value(reflect.ValueOf(&e).Elem())
+			generic[string(key)] = e
+		case v.Kind() == reflect.Map:
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err = d.value(elem); err == nil {
+				v.SetMapIndex(reflect.ValueOf(string(key)).Convert(v.Type().Key()), elem)
+			}
+		default:
+			var fv reflect.Value
+			if f := lookupField(fields, string(key)); f != nil {
+				fv = v.Field(f.index)
+			}
+			err = d.value(fv)
+		}
+		d.field = parentField
+		if err != nil {
+			return err
+		}
+	}
+
+	if generic != nil {
+		v.Set(reflect.ValueOf(generic))
+	}
+	return nil
+}
+
+func joinField(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// cachedFields returns the bencode-visible fields of t sorted by key, which is
+// also the order the encoder writes them in.
+func cachedFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{
+			name:      name,
+			index:     i,
+			omitEmpty: strings.Contains(opts, "omitempty"),
+		})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	f, _ := fieldCache.LoadOrStore(t, fields)
+	return f.([]field)
+}
+
+func lookupField(fields []field, key string) *field {
+	for i := range fields {
+		if fields[i].name == key {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, key) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		reason error
+		offset int64
+	}{
+		{"", ErrUnexpectedEnd, 0},
+		{"x", ErrInvalidType, 0},
+		{"i03e", ErrLeadingZero, 1},
+		{"i-03e", ErrLeadingZero, 2},
+		{"03:abc", ErrLeadingZero, 0},
+		{"i-0e", ErrNegativeZero, 1},
+		{"ie", ErrInvalidInteger, 1},
+		{"i1", ErrUnexpectedEnd, 2},
+		{"i9223372036854775808e", ErrIntegerOverflow, 1},
+		{"3abc", ErrInvalidStringLength, 1},
+		{"-1:a", ErrInvalidType, 0},
+		{"5:abc", ErrUnexpectedEnd, 2},
+		{"d1:bi1e1:ai2ee", ErrUnsortedKeys, 7},
+		{"d1:ai1e1:ai2ee", ErrDuplicateKey, 7},
+		{"di1ei2ee", ErrNonStringKey, 1},
+		{"d1:ae", ErrInvalidType, 4},
+		{"d1:a", ErrUnexpectedEnd, 4},
+		{"i1ei2e", ErrTrailingData, 3},
+		{"le ", ErrTrailingData, 2},
+		{strings.Repeat("l", maxDepth+1), ErrMaxDepth, maxDepth},
+	}
+	for _, test := range tests {
+		var v interface{}
+		err := Unmarshal([]byte(test.input), &v)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) || !errors.Is(err, test.reason) || syntaxErr.Offset != test.offset {
+			t.Errorf("%.20q: got %v, want %v at offset %d", test.input, err, test.reason, test.offset)
+		}
+	}
+}
+
+func TestUnmarshalTypeError(t *testing.T) {
+	var v struct {
+		Length int64 `bencode:"length"`
+	}
+	err := Unmarshal([]byte("d6:length3:abce"), &v)
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Field != "length" || typeErr.Offset != 9 {
+		t.Fatalf("got %v, want a type error for field length at offset 9", err)
+	}
+}
+
+func TestRawMessageRoundTrip(t *testing.T) {
+	type torrent struct {
+		Announce string     `bencode:"announce"`
+		Info     RawMessage `bencode:"info"`
+	}
+	input := "d8:announce3:url4:infod6:lengthi5e4:name1:a6:piecesli1eeee"
+	var v torrent
+	if err := Unmarshal([]byte(input), &v); err != nil {
+		t.Fatal(err)
+	}
+	if want := "d6:lengthi5e4:name1:a6:piecesli1eee"; string(v.Info) != want {
+		t.Errorf("Info = %q, want %q", v.Info, want)
+	}
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != input {
+		t.Errorf("Marshal = %q, want %q", out, input)
+	}
+}
+
+// compactAddr encodes an IPv4 address and port as a 6-byte string.
+type compactAddr struct {
+	IP   net.IP
+	Port int
+}
+
+var errCompactLength = errors.New("compact address is not 6 bytes")
+
+func (addr compactAddr) MarshalBencode() ([]byte, error) {
+	b := append(append([]byte(nil), addr.IP.To4()...), byte(addr.Port>>8), byte(addr.Port))
+	return MarshalString(b), nil
+}
+
+func (addr *compactAddr) UnmarshalBencode(data []byte) error {
+	b, err := UnmarshalString(data)
+	if err != nil {
+		return err
+	}
+	if len(b) != 6 {
+		return errCompactLength
+	}
+	addr.IP = net.IP(b[:4])
+	addr.Port = int(b[4])<<8 | int(b[5])
+	return nil
+}
+
+func TestMarshalerRoundTrip(t *testing.T) {
+	type peers struct {
+		Peers []compactAddr `bencode:"peers"`
+		Self  *compactAddr  `bencode:"self,omitempty"`
+	}
+	in := peers{
+		Peers: []compactAddr{{net.IPv4(10, 0, 0, 1), 6881}, {net.IPv4(10, 0, 0, 2), 51413}},
+		Self:  &compactAddr{net.IPv4(127, 0, 0, 1), 1},
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "d5:peersl6:\x0a\x00\x00\x01\x1a\xe16:\x0a\x00\x00\x02\xc8\xd5e4:self6:\x7f\x00\x00\x01\x00\x01e"
+	if string(data) != want {
+		t.Fatalf("Marshal = %q, want %q", data, want)
+	}
+	var out peers
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Peers) != 2 || out.Self == nil {
+		t.Fatalf("Unmarshal = %+v", out)
+	}
+	for i, addr := range append(out.Peers, *out.Self) {
+		wantAddr := append(in.Peers, *in.Self)[i]
+		if !addr.IP.Equal(wantAddr.IP) || addr.Port != wantAddr.Port {
+			t.Errorf("address %d = %v:%d, want %v:%d", i, addr.IP, addr.Port, wantAddr.IP, wantAddr.Port)
+		}
+	}
+}
+
+func TestUnmarshalerError(t *testing.T) {
+	var v struct {
+		Self compactAddr `bencode:"self"`
+	}
+	err := Unmarshal([]byte("d4:self3:abce"), &v)
+	var unmarshalerErr *UnmarshalerError
+	if !errors.As(err, &unmarshalerErr) || !errors.Is(err, errCompactLength) {
+		t.Fatalf("got %v, want an UnmarshalerError wrapping errCompactLength", err)
+	}
+	if unmarshalerErr.Field != "self" || unmarshalerErr.Offset != 7 {
+		t.Errorf("error in field %q at offset %d, want field self at offset 7", unmarshalerErr.Field, unmarshalerErr.Offset)
+	}
+}
+
+// badMarshaler returns two values instead of one.
+type badMarshaler struct{}
+
+func (badMarshaler) MarshalBencode() ([]byte, error) {
+	return []byte("i1ei2e"), nil
+}
+
+func TestMarshalerInvalidOutput(t *testing.T) {
+	if data, err := Marshal(badMarshaler{}); err == nil {
+		t.Fatalf("Marshal = %q, want an error", data)
+	}
+}
+
+func TestDecodeCanonical(t *testing.T) {
+	input := []byte("d1:ali1e1:be1:bd1:ci-5eee")
+	v, err := Decode(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a": []interface{}{int64(1), "b"},
+		"b": map[string]interface{}{"c": int64(-5)},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("Decode = %#v, want %#v", v, want)
+	}
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, input) {
+		t.Errorf("Marshal = %q, want %q", out, input)
+	}
+}
@@ -0,0 +1,208 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// UnsupportedTypeError is returned when a Go value has no bencode representation.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "bencode: unsupported type: " + e.Type.String()
+}
+
+// Marshal returns the canonical bencoding of v. Maps and structs become
+// dictionaries with keys in sorted order; nil pointers and interfaces inside
+// dictionaries are left out.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode writes the canonical bencoding of v to w.
+func Encode(w io.Writer, v interface{}) error {
+	e := &encodeState{}
+	if err := e.value(reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	_, err := w.Write(e.Bytes())
+	return err
+}
+
+type encodeState struct {
+	bytes.Buffer
+	scratch [64]byte
+}
+
+func (e *encodeState) writeInt(n int64) {
+	e.WriteByte('i')
+	e.Write(strconv.AppendInt(e.scratch[:0], n, 10))
+	e.WriteByte('e')
+}
+
+func (e *encodeState) writeBytes(b []byte) {
+	e.Write(strconv.AppendInt(e.scratch[:0], int64(len(b)), 10))
+	e.WriteByte(':')
+	e.Write(b)
+}
+
+func (e *encodeState) writeString(s string) {
+	e.Write(strconv.AppendInt(e.scratch[:0], int64(len(s)), 10))
+	e.WriteByte(':')
+	e.WriteString(s)
+}
+
+func (e *encodeState) value(v reflect.Value) error {
+	if !v.IsValid() {
+		return fmt.Errorf("bencode: cannot encode nil value")
+	}
+	if v.Type() == rawMessageType {
+		if v.Len() == 0 {
+			return fmt.Errorf("bencode: cannot encode empty RawMessage")
+		}
+		e.Write(v.Bytes())
+		return nil
+	}
+	if v.Type().Implements(marshalerType) || (v.CanAddr() && v.Addr().Type().Implements(marshalerType)) {
+		return e.marshaler(v)
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > 1<<63-1 {
+			return fmt.Errorf("bencode: integer %d overflows int64", u)
+		}
+		e.writeInt(int64(u))
+	case reflect.Bool:
+		if v.Bool() {
+			e.writeInt(1)
+		} else {
+			e.writeInt(0)
+		}
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Slice {
+				e.writeBytes(v.Bytes())
+			} else {
+				b := make([]byte, v.Len())
+				reflect.Copy(reflect.ValueOf(b), v)
+				e.writeBytes(b)
+			}
+			return nil
+		}
+		e.WriteByte('l')
+		for i := 0; i < v.Len(); i++ {
+			if err := e.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		e.WriteByte('e')
+	case reflect.Map:
+		return e.mapValue(v)
+	case reflect.Struct:
+		return e.structValue(v)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("bencode: cannot encode nil %s", v.Type())
+		}
+		return e.value(v.Elem())
+	default:
+		return &UnsupportedTypeError{Type: v.Type()}
+	}
+	return nil
+}
+
+func (e *encodeState) marshaler(v reflect.Value) error {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return fmt.Errorf("bencode: cannot encode nil %s", v.Type())
+	}
+	m, ok := v.Interface().(Marshaler)
+	if !ok {
+		m = v.Addr().Interface().(Marshaler)
+	}
+	b, err := m.MarshalBencode()
+	if err != nil {
+		return fmt.Errorf("bencode: error calling MarshalBencode for %s: %w", v.Type(), err)
+	}
+	d := &decodeState{data: b}
+	if err := d.value(reflect.Value{}); err != nil || d.off != len(b) {
+		return fmt.Errorf("bencode: MarshalBencode for %s returned invalid bencode", v.Type())
+	}
+	e.Write(b)
+	return nil
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+func (e *encodeState) mapValue(v reflect.Value) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return &UnsupportedTypeError{Type: v.Type()}
+	}
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	e.WriteByte('d')
+	for _, k := range keys {
+		elem := v.MapIndex(k)
+		if isNil(elem) {
+			continue
+		}
+		e.writeString(k.String())
+		if err := e.value(elem); err != nil {
+			return err
+		}
+	}
+	e.WriteByte('e')
+	return nil
+}
+
+func (e *encodeState) structValue(v reflect.Value) error {
+	e.WriteByte('d')
+	for _, f := range cachedFields(v.Type()) {
+		fv := v.Field(f.index)
+		if isNil(fv) || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		e.writeString(f.name)
+		if err := e.value(fv); err != nil {
+			return err
+		}
+	}
+	e.WriteByte('e')
+	return nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
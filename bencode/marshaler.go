@@ -0,0 +1,38 @@
+package bencode
+
+import "reflect"
+
+// Marshaler is implemented by types that encode themselves. MarshalBencode
+// must return exactly one canonical bencoded value.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types that decode themselves, typically byte
+// strings with inner structure such as compact peer lists. UnmarshalBencode
+// receives the raw encoding of the value, which has already been validated.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// UnmarshalString is a helper for Unmarshaler implementations whose
+// encoding is a single byte string.
+func UnmarshalString(data []byte) ([]byte, error) {
+	var s []byte
+	if err := Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalString encodes b as a byte string.
+func MarshalString(b []byte) []byte {
+	e := &encodeState{}
+	e.writeBytes(b)
+	return e.Bytes()
+}
@@ -0,0 +1,10 @@
+package bencode
+
+import "reflect"
+
+// RawMessage is a raw encoded bencode value. Decoding into it captures the
+// exact input bytes of the value; encoding writes them back verbatim. This is
+// what keeps info hashes stable for dictionaries with keys we don't model.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
@@ -0,0 +1,402 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TokenKind identifies the kind of a Token.
+type TokenKind int
+
+const (
+	DictStart TokenKind = iota + 1
+	DictEnd
+	ListStart
+	ListEnd
+	Integer
+	String
+	// Key is a dictionary key. Unlike String its bytes are read eagerly so
+	// the decoder can check key order.
+	Key
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case DictStart:
+		return "dict start"
+	case DictEnd:
+		return "dict end"
+	case ListStart:
+		return "list start"
+	case ListEnd:
+		return "list end"
+	case Integer:
+		return "integer"
+	case String:
+		return "string"
+	case Key:
+		return "key"
+	}
+	return fmt.Sprintf("TokenKind(%d)", int(k))
+}
+
+// Token is a single lexical element of a bencoded stream.
+type Token struct {
+	Kind   TokenKind
+	Offset int64
+	// Int is set for Integer tokens.
+	Int int64
+	// Len is the byte length of String and Key tokens.
+	Len int64
+	// Bytes holds the key for Key tokens. String contents are read on demand
+	// with Decoder.StringReader or Decoder.ReadString.
+	Bytes []byte
+}
+
+// ErrEndOfList is returned by Decode when the list it is reading has no
+// values left. The list end is left for Token to return.
+var ErrEndOfList = errors.New("bencode: end of list")
+
+// maxKeyLen bounds how much a single dictionary key may make us buffer.
+const maxKeyLen = 1 << 20
+
+type frame struct {
+	dict      bool
+	expectKey bool
+	prevKey   []byte
+	hasKey    bool
+}
+
+// Decoder reads bencode incrementally from an io.Reader. Strings are not
+// buffered: after a String token the caller may read its contents through
+// StringReader, and whatever is left unread is skipped by the next call to
+// Token. The same canonical-form rules as Unmarshal apply.
+type Decoder struct {
+	r       *bufio.Reader
+	off     int64
+	stack   []frame
+	pending int64 // unread bytes of the current string
+	done    bool  // top-level value completed
+	err     error
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Offset returns the input offset of the next unread byte.
+func (d *Decoder) Offset() int64 {
+	return d.off
+}
+
+// Depth returns the number of open lists and dictionaries.
+func (d *Decoder) Depth() int {
+	return len(d.stack)
+}
+
+func (d *Decoder) fail(off int64, reason error) error {
+	d.err = &SyntaxError{Offset: off, Err: reason}
+	return d.err
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	c, err := d.r.ReadByte()
+	if err == io.EOF {
+		return 0, d.fail(d.off, ErrUnexpectedEnd)
+	}
+	if err != nil {
+		d.err = err
+		return 0, err
+	}
+	d.off++
+	return c, nil
+}
+
+func (d *Decoder) peekByte() (byte, error) {
+	b, err := d.r.Peek(1)
+	if err == io.EOF {
+		return 0, d.fail(d.off, ErrUnexpectedEnd)
+	}
+	if err != nil {
+		d.err = err
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readInt reads digits up to terminator. The first byte has already been
+// peeked but not consumed.
+func (d *Decoder) readInt(terminator byte, allowSign bool) (int64, error) {
+	start := d.off
+	var buf [24]byte
+	b := buf[:0]
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if c == terminator {
+			break
+		}
+		isDigit := c >= '0' && c <= '9'
+		if !isDigit && !(allowSign && c == '-' && len(b) == 0) {
+			return 0, d.fail(d.off-1, malformed(terminator))
+		}
+		if len(b) == len(buf) {
+			return 0, d.fail(start, ErrIntegerOverflow)
+		}
+		b = append(b, c)
+	}
+	n, pos, err := parseCanonicalInt(b, terminator)
+	if err != nil {
+		return 0, d.fail(start+int64(pos), err)
+	}
+	return n, nil
+}
+
+func (d *Decoder) discardPending() error {
+	if d.pending == 0 {
+		return nil
+	}
+	n, err := io.CopyN(io.Discard, d.r, d.pending)
+	d.off += n
+	d.pending -= n
+	if err == io.EOF {
+		return d.fail(d.off, ErrUnexpectedEnd)
+	}
+	if err != nil {
+		d.err = err
+	}
+	return err
+}
+
+// Token returns the next token. It returns io.EOF once a complete top-level
+// value has been read and the input is exhausted.
+func (d *Decoder) Token() (Token, error) {
+	if d.err != nil {
+		return Token{}, d.err
+	}
+	if err := d.discardPending(); err != nil {
+		return Token{}, err
+	}
+	if d.done {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			return Token{}, io.EOF
+		}
+		return Token{}, d.fail(d.off, ErrTrailingData)
+	}
+
+	c, err := d.peekByte()
+	if err != nil {
+		return Token{}, err
+	}
+	tok := Token{Offset: d.off}
+
+	var top *frame
+	if len(d.stack) > 0 {
+		top = &d.stack[len(d.stack)-1]
+	}
+	if c == 'e' && top != nil && (!top.dict || top.expectKey) {
+		d.readByte()
+		tok.Kind = ListEnd
+		if top.dict {
+			tok.Kind = DictEnd
+		}
+		d.stack = d.stack[:len(d.stack)-1]
+		d.valueDone()
+		return tok, nil
+	}
+	if top != nil && top.dict && top.expectKey {
+		return d.key(top, tok)
+	}
+
+	switch {
+	case c == 'i':
+		d.readByte()
+		n, err := d.readInt('e', true)
+		if err != nil {
+			return Token{}, err
+		}
+		tok.Kind, tok.Int = Integer, n
+		d.valueDone()
+	case c >= '0' && c <= '9':
+		n, err := d.readInt(':', false)
+		if err != nil {
+			return Token{}, err
+		}
+		tok.Kind, tok.Len = String, n
+		d.pending = n
+		d.valueDone()
+	case c == 'l' || c == 'd':
+		if len(d.stack) >= maxDepth {
+			return Token{}, d.fail(d.off, ErrMaxDepth)
+		}
+		d.readByte()
+		tok.Kind = ListStart
+		if c == 'd' {
+			tok.Kind = DictStart
+		}
+		d.stack = append(d.stack, frame{dict: c == 'd', expectKey: true})
+	default:
+		return Token{}, d.fail(d.off, ErrInvalidType)
+	}
+	return tok, nil
+}
+
+func (d *Decoder) key(top *frame, tok Token) (Token, error) {
+	c, err := d.peekByte()
+	if err != nil {
+		return Token{}, err
+	}
+	if c < '0' || c > '9' {
+		return Token{}, d.fail(d.off, ErrNonStringKey)
+	}
+	n, err := d.readInt(':', false)
+	if err != nil {
+		return Token{}, err
+	}
+	if n > maxKeyLen {
+		return Token{}, d.fail(tok.Offset, ErrInvalidStringLength)
+	}
+	key := make([]byte, n)
+	read, err := io.ReadFull(d.r, key)
+	d.off += int64(read)
+	if err != nil {
+		return Token{}, d.fail(d.off, ErrUnexpectedEnd)
+	}
+	if top.hasKey {
+		switch cmp := bytes.Compare(top.prevKey, key); {
+		case cmp == 0:
+			return Token{}, d.fail(tok.Offset, ErrDuplicateKey)
+		case cmp > 0:
+			return Token{}, d.fail(tok.Offset, ErrUnsortedKeys)
+		}
+	}
+	top.prevKey, top.hasKey, top.expectKey = key, true, false
+	tok.Kind, tok.Len, tok.Bytes = Key, n, key
+	return tok, nil
+}
+
+// valueDone records that a complete value was read at the current level.
+func (d *Decoder) valueDone() {
+	if len(d.stack) == 0 {
+		d.done = true
+		return
+	}
+	if top := &d.stack[len(d.stack)-1]; top.dict {
+		top.expectKey = true
+	}
+}
+
+// StringReader returns a reader over the unread contents of the string
+// returned by the last Token call. It is only valid until the next call to
+// Token.
+func (d *Decoder) StringReader() io.Reader {
+	return &stringReader{d: d}
+}
+
+// ReadString reads the whole of the current string, refusing strings larger
+// than max bytes.
+func (d *Decoder) ReadString(max int64) ([]byte, error) {
+	if d.pending > max {
+		return nil, fmt.Errorf("bencode: string of %d bytes exceeds limit of %d", d.pending, max)
+	}
+	return io.ReadAll(d.StringReader())
+}
+
+type stringReader struct {
+	d *Decoder
+}
+
+func (s *stringReader) Read(p []byte) (int, error) {
+	d := s.d
+	if d.pending == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > d.pending {
+		p = p[:d.pending]
+	}
+	n, err := d.r.Read(p)
+	d.off += int64(n)
+	d.pending -= int64(n)
+	if err == io.EOF && d.pending > 0 {
+		return n, d.fail(d.off, ErrUnexpectedEnd)
+	}
+	if err != nil && err != io.EOF {
+		d.err = err
+	}
+	return n, err
+}
+
+// Skip consumes the rest of the value whose start token was just returned.
+// After a DictStart or ListStart it reads through the matching end token;
+// after any other token it is a no-op.
+func (d *Decoder) Skip(start Token) error {
+	if start.Kind != DictStart && start.Kind != ListStart {
+		return nil
+	}
+	depth := len(d.stack)
+	for len(d.stack) >= depth {
+		if _, err := d.Token(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode reads the next complete value and unmarshals it into v, for pulling
+// a single field out of a stream once the scan has reached it.
+func (d *Decoder) Decode(v interface{}) error {
+	if d.err != nil {
+		return d.err
+	}
+	if n := len(d.stack); n > 0 && d.stack[n-1].dict && d.stack[n-1].expectKey {
+		return fmt.Errorf("bencode: Decode called at a dictionary key")
+	}
+	if n := len(d.stack); n > 0 && !d.stack[n-1].dict {
+		// don't read past the end of the list into its parent
+		if err := d.discardPending(); err != nil {
+			return err
+		}
+		c, err := d.peekByte()
+		if err != nil {
+			return err
+		}
+		if c == 'e' {
+			return ErrEndOfList
+		}
+	}
+	var buf bytes.Buffer
+	depth := len(d.stack)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok.Kind {
+		case DictStart:
+			buf.WriteByte('d')
+		case ListStart:
+			buf.WriteByte('l')
+		case DictEnd, ListEnd:
+			buf.WriteByte('e')
+		case Integer:
+			fmt.Fprintf(&buf, "i%de", tok.Int)
+		case Key:
+			fmt.Fprintf(&buf, "%d:", tok.Len)
+			buf.Write(tok.Bytes)
+		case String:
+			fmt.Fprintf(&buf, "%d:", tok.Len)
+			if _, err := io.Copy(&buf, d.StringReader()); err != nil {
+				return err
+			}
+		}
+		if len(d.stack) == depth {
+			break
+		}
+	}
+	return Unmarshal(buf.Bytes(), v)
+}
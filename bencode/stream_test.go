@@ -0,0 +1,75 @@
+package bencode
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecoderDecodeStopsAtListEnd(t *testing.T) {
+	d := NewDecoder(strings.NewReader("d4:listli1ei2ee4:spami3ee"))
+	for _, want := range []TokenKind{DictStart, Key, ListStart} {
+		if tok, err := d.Token(); err != nil || tok.Kind != want {
+			t.Fatalf("Token() = %v, %v; want %v", tok.Kind, err, want)
+		}
+	}
+	for _, want := range []int64{1, 2} {
+		var n int64
+		if err := d.Decode(&n); err != nil || n != want {
+			t.Fatalf("Decode() = %d, %v; want %d", n, err, want)
+		}
+	}
+	var n int64
+	if err := d.Decode(&n); err != ErrEndOfList {
+		t.Fatalf("Decode() at the list end = %v, want ErrEndOfList", err)
+	}
+	// the stream is still positioned at the list end
+	if tok, err := d.Token(); err != nil || tok.Kind != ListEnd {
+		t.Fatalf("Token() = %v, %v; want list end", tok.Kind, err)
+	}
+	if tok, err := d.Token(); err != nil || tok.Kind != Key || string(tok.Bytes) != "spam" {
+		t.Fatalf("Token() = %v %q, %v; want key spam", tok.Kind, tok.Bytes, err)
+	}
+	if err := d.Decode(&n); err != nil || n != 3 {
+		t.Fatalf("Decode() = %d, %v; want 3", n, err)
+	}
+}
+
+func TestDecoderKeyReadError(t *testing.T) {
+	errRead := errors.New("read failed")
+	d := NewDecoder(io.MultiReader(strings.NewReader("d"), iotest.ErrReader(errRead)))
+	if _, err := d.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Token(); !errors.Is(err, errRead) {
+		t.Fatalf("Token() error = %v, want the read error", err)
+	}
+}
+
+func TestDecoderTokens(t *testing.T) {
+	tests := []struct {
+		input  string
+		reason error
+		offset int64
+	}{
+		{"d1:bi1e1:ai2ee", ErrUnsortedKeys, 7},
+		{"d1:ai1e1:ai2ee", ErrDuplicateKey, 7},
+		{"di1ei2ee", ErrNonStringKey, 1},
+		{"li01ee", ErrLeadingZero, 2},
+		{"i1ei2e", ErrTrailingData, 3},
+		{"l", ErrUnexpectedEnd, 1},
+	}
+	for _, test := range tests {
+		d := NewDecoder(strings.NewReader(test.input))
+		var err error
+		for err == nil {
+			_, err = d.Token()
+		}
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) || !errors.Is(err, test.reason) || syntaxErr.Offset != test.offset {
+			t.Errorf("%q: got %v, want %v at offset %d", test.input, err, test.reason, test.offset)
+		}
+	}
+}
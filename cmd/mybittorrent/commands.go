@@ -1,11 +1,15 @@
 package main
 
 import (
-	"encoding/binary"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"net"
+	"io"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/torrent"
 )
@@ -17,38 +21,467 @@ const (
 
 func printInfo(meta *torrent.TorrentFileMeta) {
 
-	fmt.Printf("Tracker URL: %s", meta.TorrentFileInfo.Announce)
-	fmt.Printf("Length: %d\n", meta.TorrentFileInfo.Info.Length)
-	fmt.Printf("Info Hash: %x\n", meta.InfoHashBytes)
-	fmt.Printf("Piece Length: %d\n", meta.TorrentFileInfo.Info.PieceLength)
+	fmt.Printf("Tracker URL: %s\n", meta.TorrentFileInfo.Announce)
+	if len(meta.TorrentFileInfo.AnnounceList) > 0 {
+		fmt.Println("Tracker Tiers:")
+		for i, tier := range meta.Trackers() {
+			fmt.Printf("  %d: %s\n", i, strings.Join(tier, " "))
+		}
+	}
+	fmt.Printf("Length: %d\n", meta.TotalLength())
+	if meta.IsV1() {
+		fmt.Printf("Info Hash: %x\n", meta.InfoHashBytes)
+	}
+	if meta.IsV2() {
+		fmt.Printf("Info Hash v2: %x\n", meta.InfoHashV2)
+		fmt.Printf("Meta Version: %s\n", meta.Version())
+	}
+	fmt.Printf("Piece Length: %d\n", meta.PieceLength())
+	if meta.IsPrivate() {
+		fmt.Println("Private: yes")
+	}
+	if source := meta.TorrentFileInfo.Info.Source; source != "" {
+		fmt.Printf("Source: %s\n", source)
+	}
+	if len(meta.TorrentFileInfo.URLList) > 0 {
+		fmt.Printf("Web Seeds: %s\n", strings.Join(meta.TorrentFileInfo.URLList, " "))
+	}
+	if len(meta.TorrentFileInfo.HTTPSeeds) > 0 {
+		fmt.Printf("HTTP Seeds: %s\n", strings.Join(meta.TorrentFileInfo.HTTPSeeds, " "))
+	}
+	if meta.IsMultiFile() {
+		fmt.Println("Files:")
+		printFileTree(meta.Files())
+	}
 	fmt.Println("Pieces Hashes:")
-	for i := 0; i < len(meta.TorrentFileInfo.Info.Pieces); i += 20 {
-		fmt.Printf("%x\n", meta.TorrentFileInfo.Info.Pieces[i:i+20])
+	for i := 0; i < meta.NumPieces(); i++ {
+		fmt.Printf("%x\n", meta.PieceHash(i))
 	}
 }
 
-func printIPs(trackerResp torrent.TrackerResponse) {
-	offset := 0
-	for offset+6 <= len(trackerResp.Peers) {
-		ip := net.IP(trackerResp.Peers[offset : offset+4])
+// printFileTree prints files as an indented directory tree, printing each
+// directory once before the first file beneath it.
+func printFileTree(files []torrent.FileEntry) {
+	var previous []string
+	for _, file := range files {
+		if file.IsPadding() {
+			continue
+		}
+		dirs := file.Path[:len(file.Path)-1]
+		common := 0
+		for common < len(dirs) && common < len(previous) && dirs[common] == previous[common] {
+			common++
+		}
+		for depth := common; depth < len(dirs); depth++ {
+			fmt.Printf("%s%s/\n", strings.Repeat("  ", depth+1), dirs[depth])
+		}
+		var attrs string
+		if file.IsSymlink() {
+			attrs += " -> " + strings.Join(file.SymlinkPath, "/")
+		}
+		if file.IsExecutable() {
+			attrs += " [executable]"
+		}
+		if file.IsHidden() {
+			attrs += " [hidden]"
+		}
+		fmt.Printf("%s%s (%d bytes)%s\n", strings.Repeat("  ", len(dirs)+1), file.Path[len(dirs)], file.Length, attrs)
+		previous = dirs
+	}
+}
+
+func printMagnet(magnet *torrent.Magnet) {
+	if len(magnet.Trackers) > 0 {
+		fmt.Printf("Tracker URL: %s\n", magnet.Trackers[0])
+	}
+	if magnet.InfoHash != nil {
+		fmt.Printf("Info Hash: %x\n", magnet.InfoHash)
+	}
+	if magnet.InfoHashV2 != nil {
+		fmt.Printf("Info Hash v2: %x\n", magnet.InfoHashV2)
+	}
+	if magnet.DisplayName != "" {
+		fmt.Printf("Name: %s\n", magnet.DisplayName)
+	}
+	if magnet.Length > 0 {
+		fmt.Printf("Length: %d\n", magnet.Length)
+	}
+	if len(magnet.Trackers) > 1 {
+		fmt.Printf("Trackers: %s\n", strings.Join(magnet.Trackers, " "))
+	}
+	if len(magnet.WebSeeds) > 0 {
+		fmt.Printf("Web Seeds: %s\n", strings.Join(magnet.WebSeeds, " "))
+	}
+	if len(magnet.Peers) > 0 {
+		fmt.Printf("Peers: %s\n", strings.Join(magnet.Peers, " "))
+	}
+	if len(magnet.SelectOnly) > 0 {
+		fmt.Printf("Select Only: %v\n", magnet.SelectOnly)
+	}
+}
 
-		port := binary.BigEndian.Uint16([]byte(trackerResp.Peers[offset+4 : offset+6]))
-		fmt.Printf("%s:%d\n", ip.String(), port)
-		offset += 6
+func printIPs(trackerResp torrent.TrackerResponse) {
+	for _, peer := range trackerResp.Peers {
+		fmt.Println(peer)
+	}
+	for _, peer := range trackerResp.Peers6 {
+		fmt.Println(peer)
 	}
 }
 
-func DecodeCommand(bencodedValue string) (string, error) {
+// DecodeCommand handles `decode [-format json|hex|base64|tree] [-file path] [value|-]`.
+func DecodeCommand(args []string) (string, error) {
+	flags := flag.NewFlagSet("decode", flag.ContinueOnError)
+	formatName := flags.String("format", string(torrent.FormatJSON), "output format: json, hex, base64 or tree")
+	fileName := flags.String("file", "", "read the bencoded value from a file")
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	format, err := torrent.ParseOutputFormat(*formatName)
+	if err != nil {
+		return "", err
+	}
+
+	var bencodedValue string
+	switch {
+	case *fileName != "":
+		data, err := readInput(*fileName)
+		if err != nil {
+			return "", err
+		}
+		bencodedValue = string(data)
+	case flags.Arg(0) == "-":
+		data, err := readInput("-")
+		if err != nil {
+			return "", err
+		}
+		bencodedValue = string(data)
+	default:
+		bencodedValue = flags.Arg(0)
+	}
+
 	decoded, err := torrent.DecodeBencode(bencodedValue)
 	if err != nil {
 		return "", err
 	}
+	return torrent.FormatValue(decoded, format)
+}
 
-	jsonOutput, err := json.Marshal(decoded)
+// QueryCommand handles `query [-format F] [-paths] <file|-> <path>`.
+func QueryCommand(args []string) (string, error) {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	formatName := flags.String("format", string(torrent.FormatJSON), "output format: json, hex, base64 or tree")
+	showPaths := flags.Bool("paths", false, "prefix each result with its path")
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	if flags.NArg() != 2 {
+		return "", fmt.Errorf("usage: query [-format F] [-paths] <file|-> <path>")
+	}
+	format, err := torrent.ParseOutputFormat(*formatName)
 	if err != nil {
 		return "", err
 	}
-	return string(jsonOutput), nil
+	data, err := readInput(flags.Arg(0))
+	if err != nil {
+		return "", err
+	}
+
+	results, err := torrent.Query(data, flags.Arg(1))
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, 0, len(results))
+	for _, result := range results {
+		output, err := torrent.FormatValue(result.Value, format)
+		if err != nil {
+			return "", err
+		}
+		if *showPaths {
+			output = result.Path + ": " + output
+		}
+		lines = append(lines, output)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// readInput reads a whole file, or stdin when name is "-".
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
+
+func DiffCommand(oldFileName, newFileName string) (*torrent.DiffResult, error) {
+	oldData, err := readInput(oldFileName)
+	if err != nil {
+		return nil, err
+	}
+	newData, err := readInput(newFileName)
+	if err != nil {
+		return nil, err
+	}
+	return torrent.DiffBencode(oldData, newData)
+}
+
+// CreateCommand handles `create [options] <path>` and returns the path of the
+// written torrent.
+func CreateCommand(args []string) (*torrent.TorrentFileMeta, string, error) {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	outputPath := flags.String("o", "", "output .torrent path (default <name>.torrent)")
+	name := flags.String("name", "", "torrent name (default base name of path)")
+	pieceLength := flags.Int64("piece-length", 0, "piece length in bytes (default chosen from total size)")
+	announce := flags.String("announce", "", "primary tracker URL")
+	announceList := flags.String("announce-list", "", "tracker tiers: URLs separated by ',' within a tier and '|' between tiers")
+	comment := flags.String("comment", "", "free-form comment")
+	createdBy := flags.String("created-by", "mybittorrent", "creating program")
+	noDate := flags.Bool("no-date", false, "omit the creation date")
+	private := flags.Bool("private", false, "set the private flag (BEP 27)")
+	source := flags.String("source", "", "source tag, usually the tracker's name")
+	workers := flags.Int("workers", 0, "hashing goroutines (default number of CPUs)")
+	align := flags.Bool("align", false, "insert padding files so each file starts on a piece boundary (BEP 47)")
+	if err := flags.Parse(args); err != nil {
+		return nil, "", err
+	}
+	if flags.NArg() != 1 {
+		return nil, "", fmt.Errorf("usage: create [options] <file|directory>")
+	}
+
+	options := torrent.CreateOptions{
+		Path:        flags.Arg(0),
+		Name:        *name,
+		PieceLength: *pieceLength,
+		Announce:    *announce,
+		Comment:     *comment,
+		CreatedBy:   *createdBy,
+		Private:     *private,
+		Source:      *source,
+		Workers:     *workers,
+		Align:       *align,
+	}
+	if !*noDate {
+		options.CreationDate = time.Now()
+	}
+	if *announceList != "" {
+		for _, tier := range strings.Split(*announceList, "|") {
+			options.AnnounceList = append(options.AnnounceList, strings.Split(tier, ","))
+		}
+		if options.Announce == "" {
+			options.Announce = options.AnnounceList[0][0]
+		}
+	}
+
+	meta, err := torrent.CreateTorrent(options)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := torrent.Marshal(meta.TorrentFileInfo)
+	if err != nil {
+		return nil, "", err
+	}
+	if *outputPath == "" {
+		*outputPath = meta.TorrentFileInfo.Info.Name + ".torrent"
+	}
+	if err := os.WriteFile(*outputPath, data, 0644); err != nil {
+		return nil, "", err
+	}
+	return meta, *outputPath, nil
+}
+
+// EditCommand handles `edit [options] <torrent>`. Top-level fields are
+// rewritten in place (or to -o) with the info dictionary untouched; changing
+// -source or -private needs -rehash since it changes the info hash. An empty
+// value removes the field.
+func EditCommand(args []string) (*torrent.EditResult, string, error) {
+	flags := flag.NewFlagSet("edit", flag.ContinueOnError)
+	outputPath := flags.String("o", "", "output .torrent path (default edit in place)")
+	announce := flags.String("announce", "", "primary tracker URL")
+	announceList := flags.String("announce-list", "", "tracker tiers: URLs separated by ',' within a tier and '|' between tiers")
+	urlList := flags.String("url-list", "", "web seed URLs separated by ','")
+	httpSeeds := flags.String("httpseeds", "", "BEP 17 http seed URLs separated by ','")
+	comment := flags.String("comment", "", "free-form comment")
+	createdBy := flags.String("created-by", "", "creating program")
+	rehash := flags.Bool("rehash", false, "allow -source and -private, which change the info hash")
+	source := flags.String("source", "", "source tag, usually the tracker's name")
+	private := flags.Bool("private", false, "set or clear the private flag (BEP 27)")
+	if err := flags.Parse(args); err != nil {
+		return nil, "", err
+	}
+	if flags.NArg() != 1 {
+		return nil, "", fmt.Errorf("usage: edit [options] <torrent>")
+	}
+	if *outputPath == "" {
+		*outputPath = flags.Arg(0)
+	}
+
+	options := torrent.EditOptions{Set: map[string]interface{}{}}
+	setOrDelete := func(key string, value interface{}, empty bool) {
+		if empty {
+			options.Delete = append(options.Delete, key)
+		} else {
+			options.Set[key] = value
+		}
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "announce":
+			setOrDelete("announce", *announce, *announce == "")
+		case "announce-list":
+			var tiers [][]string
+			for _, tier := range strings.Split(*announceList, "|") {
+				tiers = append(tiers, strings.Split(tier, ","))
+			}
+			setOrDelete("announce-list", tiers, *announceList == "")
+		case "url-list":
+			setOrDelete("url-list", strings.Split(*urlList, ","), *urlList == "")
+		case "httpseeds":
+			setOrDelete("httpseeds", strings.Split(*httpSeeds, ","), *httpSeeds == "")
+		case "comment":
+			setOrDelete("comment", *comment, *comment == "")
+		case "created-by":
+			setOrDelete("created by", *createdBy, *createdBy == "")
+		case "source", "private":
+			if !*rehash {
+				err = fmt.Errorf("-%s changes the info hash; pass -rehash to confirm", f.Name)
+			}
+			if f.Name == "source" {
+				options.Source = source
+			} else {
+				options.Private = private
+			}
+		}
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return nil, "", err
+	}
+	result, err := torrent.EditTorrent(data, options)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.WriteFile(*outputPath, result.Data, 0644); err != nil {
+		return nil, "", err
+	}
+	return result, *outputPath, nil
+}
+
+// LintCommand handles `lint [-json] <torrent>` and reports whether any
+// finding is an error.
+func LintCommand(args []string) (string, bool, error) {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print the findings as JSON")
+	if err := flags.Parse(args); err != nil {
+		return "", false, err
+	}
+	if flags.NArg() != 1 {
+		return "", false, fmt.Errorf("usage: lint [-json] <torrent>")
+	}
+	data, err := readInput(flags.Arg(0))
+	if err != nil {
+		return "", false, err
+	}
+	findings := torrent.LintTorrent(data)
+	failed := false
+	lines := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		failed = failed || finding.Severity == torrent.SeverityError
+		lines = append(lines, finding.String())
+	}
+	if *jsonOutput {
+		if findings == nil {
+			findings = []torrent.Finding{}
+		}
+		output, err := json.MarshalIndent(findings, "", "  ")
+		return string(output), failed, err
+	}
+	if len(lines) == 0 {
+		return "No problems found", false, nil
+	}
+	return strings.Join(lines, "\n"), failed, nil
+}
+
+// VerifyCommand handles `verify [-json] [-workers N] <torrent> <path>`.
+func VerifyCommand(args []string) (string, bool, error) {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print the report as JSON")
+	workers := flags.Int("workers", 0, "hashing goroutines (default number of CPUs)")
+	if err := flags.Parse(args); err != nil {
+		return "", false, err
+	}
+	if flags.NArg() != 2 {
+		return "", false, fmt.Errorf("usage: verify [-json] <torrent> <path>")
+	}
+	meta, err := torrent.ParseTorrentFile(flags.Arg(0))
+	if err != nil {
+		return "", false, err
+	}
+	storage, err := torrent.OpenFileStorage(meta, flags.Arg(1))
+	if err != nil {
+		return "", false, err
+	}
+	defer storage.Close()
+
+	report, err := torrent.VerifyStorage(meta, storage, *workers)
+	if err != nil {
+		return "", false, err
+	}
+	if *jsonOutput {
+		output, err := json.MarshalIndent(report, "", "  ")
+		return string(output), report.Complete, err
+	}
+	return report.String(), report.Complete, nil
+}
+
+// HTTPSeedServeCommand handles
+// `httpseed_serve [-addr host:port] [-max-active n] [-retry-after s] <torrent> <path> [<torrent> <path>...]`.
+func HTTPSeedServeCommand(args []string) error {
+	flags := flag.NewFlagSet("httpseed_serve", flag.ContinueOnError)
+	addr := flags.String("addr", ":6880", "listen address")
+	maxActive := flags.Int("max-active", 0, "concurrent requests before answering busy (0 for no limit)")
+	retryAfter := flags.Int("retry-after", 5, "seconds busy clients are told to wait")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 || flags.NArg()%2 != 0 {
+		return fmt.Errorf("usage: httpseed_serve [-addr host:port] [-max-active n] [-retry-after s] <torrent> <path> [<torrent> <path>...]")
+	}
+	server := torrent.NewHTTPSeedServer()
+	server.MaxActive = *maxActive
+	server.RetryAfter = *retryAfter
+	for i := 0; i < flags.NArg(); i += 2 {
+		meta, err := torrent.ParseTorrentFile(flags.Arg(i))
+		if err != nil {
+			return err
+		}
+		storage, err := torrent.OpenFileStorage(meta, flags.Arg(i+1))
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+		if err := server.Add(meta, storage); err != nil {
+			return err
+		}
+		fmt.Printf("Seeding %s (%x) from %s\n", meta.TorrentFileInfo.Info.Name, meta.InfoHashBytes, flags.Arg(i+1))
+	}
+	fmt.Printf("Listening on %s\n", *addr)
+	return http.ListenAndServe(*addr, server)
+}
+
+func EncodeCommand(jsonInput string) ([]byte, error) {
+	if jsonInput == "-" {
+		data, err := readInput("-")
+		if err != nil {
+			return nil, err
+		}
+		jsonInput = string(data)
+	}
+	return torrent.EncodeJSON([]byte(jsonInput))
 }
 
 func InfoCommand(fileName string) error {
@@ -73,6 +506,33 @@ func PeersCommand(fileName string) (torrent.TrackerResponse, error) {
 	return trackerResp, nil
 }
 
+// ScrapeCommand handles `scrape <torrent>`, asking every tracker for swarm
+// stats, and reports whether any of them answered.
+func ScrapeCommand(fileName string) (string, bool, error) {
+	meta, err := torrent.ParseTorrentFile(fileName)
+	if err != nil {
+		return "", false, err
+	}
+	var lines []string
+	answered := false
+	for _, tier := range meta.Trackers() {
+		for _, trackerURL := range tier {
+			stats, err := torrent.Scrape(trackerURL, [][]byte{meta.InfoHashBytes})
+			if err != nil {
+				lines = append(lines, fmt.Sprintf("%s: %s", trackerURL, err))
+				continue
+			}
+			answered = true
+			lines = append(lines, fmt.Sprintf("%s: seeders %d leechers %d completed %d",
+				trackerURL, stats[0].Complete, stats[0].Incomplete, stats[0].Downloaded))
+		}
+	}
+	if len(lines) == 0 {
+		return "", false, fmt.Errorf("torrent has no trackers")
+	}
+	return strings.Join(lines, "\n"), answered, nil
+}
+
 func HandshakeCommand(fileName string, peer string) (string, error) {
 	meta, err := torrent.ParseTorrentFile(fileName)
 	if err != nil {
@@ -126,24 +586,208 @@ func DownloadFileSubCommand(outputFilePath, torrentFileName string) {
 	})
 
 	fmt.Println("Retrieve peers...")
-	peersResponse, err := client.RequestPeers(meta)
-	if err != nil {
+	stats := torrent.NewTransferStats(meta.TotalLength())
+	announcer := client.NewAnnouncer(meta, stats)
+	// the announcer re-announces early while the download is short of peers,
+	// and every announce adds to them
+	peers := torrent.NewPeerList()
+	peers.OnChange = announcer.SetPeerCount
+	addPeers := func(result *torrent.PeersResult) {
+		for _, peerAddress := range result.Peers {
+			peers.Add(client.TCPPeer(peerAddress))
+		}
+	}
+	announcer.OnPeers = addPeers
+	peersResponse, err := announcer.Start()
+	// sends stopped, and completed first if we finish
+	defer announcer.Stop()
+	if err == nil {
+		addPeers(peersResponse)
+	}
+	// web seeds fill in for pieces, or a whole tracker, that fail
+	seeds := append(meta.WebSeeds(nil), meta.HTTPSeeds(nil)...)
+	if peers.Len() == 0 && len(seeds) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no peers or seeds")
+		}
 		fmt.Println(err)
 		return
 	}
-	peerAddress := peersResponse.Peers[0]
 	// peerAddr := fmt.Sprintf("%s:%d", peer.IP, peer.Port)
 	// cli := NewClient("00112233445566778899")
-	data, err := client.DownloadFile(meta, peerAddress)
+	storage, err := torrent.NewFileStorage(meta, outputFilePath)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	file, err := os.Create(outputFilePath)
-	if err != nil {
-		panic(err)
+	defer storage.Close()
+	if err := torrent.DownloadPieces(meta, peers, seeds, stats.CountWrites(storage)); err != nil {
+		fmt.Println(err)
+		return
 	}
-	defer file.Close()
-	file.Write(data)
+	if err := storage.Finalize(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	announcer.Completed()
 	fmt.Printf("Downloaded test.torrent to to %s\n", outputFilePath)
 }
+
+// magnetPeers parses link and collects peers for it: the link's x.pe peers
+// first, then whatever its trackers return.
+func magnetPeers(link string) (*torrent.Magnet, *torrent.Client, []string, error) {
+	magnet, err := torrent.ParseMagnet(link)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	client := torrent.NewClient(magnet.TrackerMeta(), &torrent.Config{
+		PeerId: PeerId,
+		Port:   Port,
+	})
+	var trackerPeers []string
+	if len(magnet.Trackers) > 0 {
+		peersResponse, err := client.RequestPeers(client.Meta)
+		if err != nil && len(magnet.Peers) == 0 {
+			return nil, nil, nil, err
+		}
+		if err == nil {
+			trackerPeers = peersResponse.Peers
+		}
+	}
+	peers := client.MagnetPeers(magnet, trackerPeers)
+	if len(peers) == 0 {
+		return nil, nil, nil, fmt.Errorf("no peers for magnet link")
+	}
+	return magnet, client, peers, nil
+}
+
+// magnetMeta fetches the info dictionary from the first peer that serves it.
+func magnetMeta(link string) (*torrent.TorrentFileMeta, *torrent.Client, []string, error) {
+	magnet, client, peers, err := magnetPeers(link)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var failures []string
+	for _, peerAddress := range peers {
+		rawInfo, err := client.FetchMetadata(peerAddress, magnet.WireInfoHash())
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		meta, err := torrent.MetaFromMagnet(magnet, rawInfo)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		client.Meta = meta
+		// now that we know whether the torrent is private, drop the x.pe
+		// peers (the metadata may even have come from one) if it is
+		peers = client.MagnetPeers(magnet, peers[len(magnet.Peers):])
+		if len(peers) == 0 {
+			return nil, nil, nil, fmt.Errorf("no tracker peers for private torrent")
+		}
+		return meta, client, peers, nil
+	}
+	return nil, nil, nil, fmt.Errorf("unable to fetch metadata: %s", strings.Join(failures, "; "))
+}
+
+func MagnetHandshakeCommand(link string) (*torrent.PeerExtensions, error) {
+	magnet, client, peers, err := magnetPeers(link)
+	if err != nil {
+		return nil, err
+	}
+	extensions, err := client.ConnectExtended(peers[0], magnet.WireInfoHash())
+	if err != nil {
+		return nil, err
+	}
+	client.Close(peers[0])
+	return extensions, nil
+}
+
+// MagnetInfoCommand handles `magnet_info [-o file.torrent] <magnet-link>`.
+func MagnetInfoCommand(args []string) error {
+	flags := flag.NewFlagSet("magnet_info", flag.ContinueOnError)
+	outputPath := flags.String("o", "", "save the fetched metadata as a .torrent file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: magnet_info [-o file.torrent] <magnet-link>")
+	}
+	meta, _, _, err := magnetMeta(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	printInfo(meta)
+	if *outputPath != "" {
+		data, err := torrent.Marshal(meta.TorrentFileInfo)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*outputPath, data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Saved metadata to %s\n", *outputPath)
+	}
+	return nil
+}
+
+// MagnetDownloadPieceCommand handles `magnet_download_piece -o <output> <magnet-link> <piece>`.
+func MagnetDownloadPieceCommand(args []string) (string, int, error) {
+	flags := flag.NewFlagSet("magnet_download_piece", flag.ContinueOnError)
+	outputPath := flags.String("o", "", "output file")
+	if err := flags.Parse(args); err != nil {
+		return "", 0, err
+	}
+	if flags.NArg() != 2 || *outputPath == "" {
+		return "", 0, fmt.Errorf("usage: magnet_download_piece -o <output> <magnet-link> <piece>")
+	}
+	pieceIndex, err := strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		return "", 0, err
+	}
+	meta, client, peers, err := magnetMeta(flags.Arg(0))
+	if err != nil {
+		return "", 0, err
+	}
+	for _, peerAddress := range peers {
+		var data []byte
+		// FetchPiece checks the piece against its v1 hash or merkle tree
+		data, err = client.TCPPeer(peerAddress).FetchPiece(meta, pieceIndex)
+		if err != nil {
+			fmt.Printf("piece %d from %s failed: %s\n", pieceIndex, peerAddress, err)
+			continue
+		}
+		return *outputPath, pieceIndex, os.WriteFile(*outputPath, data, 0644)
+	}
+	return "", 0, err
+}
+
+// MagnetDownloadCommand handles `magnet_download -o <output> <magnet-link>`.
+func MagnetDownloadCommand(args []string) (string, error) {
+	flags := flag.NewFlagSet("magnet_download", flag.ContinueOnError)
+	outputPath := flags.String("o", "", "output path")
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	if flags.NArg() != 1 || *outputPath == "" {
+		return "", fmt.Errorf("usage: magnet_download -o <output> <magnet-link>")
+	}
+	meta, client, peers, err := magnetMeta(flags.Arg(0))
+	if err != nil {
+		return "", err
+	}
+	storage, err := torrent.NewFileStorage(meta, *outputPath)
+	if err != nil {
+		return "", err
+	}
+	defer storage.Close()
+	piecePeers := torrent.NewPeerList()
+	for _, peerAddress := range peers {
+		piecePeers.Add(client.TCPPeer(peerAddress))
+	}
+	seeds := append(meta.WebSeeds(nil), meta.HTTPSeeds(nil)...)
+	if err := torrent.DownloadPieces(meta, piecePeers, seeds, storage); err != nil {
+		return "", err
+	}
+	return *outputPath, storage.Finalize()
+}
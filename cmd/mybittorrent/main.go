@@ -4,18 +4,127 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/torrent"
 )
 
 func main() {
 	command := os.Args[1]
 	switch command {
 	case "decode":
-		output, err := DecodeCommand(os.Args[2])
+		output, err := DecodeCommand(os.Args[2:])
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 		fmt.Println(output)
+	case "encode":
+		output, err := EncodeCommand(os.Args[2])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(output)
+	case "query":
+		output, err := QueryCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(output)
+	case "diff":
+		result, err := DiffCommand(os.Args[2], os.Args[3])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if output := result.String(); output != "" {
+			fmt.Println(output)
+		}
+		if len(result.Differences) > 0 {
+			os.Exit(1)
+		}
+	case "create":
+		meta, outputPath, err := CreateCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Created %s\n", outputPath)
+		fmt.Printf("Info Hash: %x\n", meta.InfoHashBytes)
+	case "edit":
+		result, outputPath, err := EditCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s\n", outputPath)
+		if result.InfoChanged() {
+			fmt.Printf("Info Hash: %x -> %x\n", result.OldInfoHash, result.NewInfoHash)
+		} else {
+			fmt.Printf("Info Hash: %x (unchanged)\n", result.NewInfoHash)
+		}
+	case "lint":
+		output, failed, err := LintCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		fmt.Println(output)
+		if failed {
+			os.Exit(1)
+		}
+	case "verify":
+		output, complete, err := VerifyCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		fmt.Println(output)
+		if !complete {
+			os.Exit(1)
+		}
+	case "httpseed_serve":
+		if err := HTTPSeedServeCommand(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case "magnet_parse":
+		magnet, err := torrent.ParseMagnet(os.Args[2])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		printMagnet(magnet)
+	case "magnet_handshake":
+		extensions, err := MagnetHandshakeCommand(os.Args[2])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Peer ID: %s\n", extensions.PeerId)
+		if extensions.UtMetadataID > 0 {
+			fmt.Printf("Peer Metadata Extension ID: %d\n", extensions.UtMetadataID)
+		}
+	case "magnet_info":
+		if err := MagnetInfoCommand(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case "magnet_download_piece":
+		outputPath, pieceIndex, err := MagnetDownloadPieceCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Piece %d downloaded to %s\n", pieceIndex, outputPath)
+	case "magnet_download":
+		outputPath, err := MagnetDownloadCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Downloaded magnet to %s\n", outputPath)
 	case "info":
 		err := InfoCommand(os.Args[2])
 		if err != nil {
@@ -30,6 +139,17 @@ func main() {
 		}
 		printIPs(output)
 
+	case "scrape":
+		output, answered, err := ScrapeCommand(os.Args[2])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(output)
+		if !answered {
+			os.Exit(1)
+		}
+
 	case "handshake":
 		peerId, err := HandshakeCommand(os.Args[2], os.Args[3])
 		if err != nil {
@@ -0,0 +1,303 @@
+package torrent
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type AnnounceEvent string
+
+const (
+	EventNone      AnnounceEvent = ""
+	EventStarted   AnnounceEvent = "started"
+	EventCompleted AnnounceEvent = "completed"
+	EventStopped   AnnounceEvent = "stopped"
+)
+
+const (
+	// defaultAnnounceInterval and defaultMinAnnounceInterval apply when the
+	// tracker doesn't say.
+	defaultAnnounceInterval    = 30 * time.Minute
+	defaultMinAnnounceInterval = time.Minute
+	// announceRetry is the first back-off after every tracker failed; it
+	// doubles with each further failure up to the announce interval.
+	announceRetry = 15 * time.Second
+	// defaultMinPeers is the peer count below which we re-announce as soon
+	// as min interval allows.
+	defaultMinPeers = 5
+)
+
+// TransferStats are the counters reported to trackers. They are updated
+// atomically so downloads can count while the announcer reads.
+type TransferStats struct {
+	uploaded   int64
+	downloaded int64
+	left       int64
+}
+
+func NewTransferStats(left int64) *TransferStats {
+	return &TransferStats{left: left}
+}
+
+func (stats *TransferStats) AddUploaded(n int64) {
+	atomic.AddInt64(&stats.uploaded, n)
+}
+
+// AddDownloaded counts n verified bytes, which are no longer left.
+func (stats *TransferStats) AddDownloaded(n int64) {
+	atomic.AddInt64(&stats.downloaded, n)
+	atomic.AddInt64(&stats.left, -n)
+}
+
+func (stats *TransferStats) Uploaded() int64 {
+	return atomic.LoadInt64(&stats.uploaded)
+}
+
+func (stats *TransferStats) Downloaded() int64 {
+	return atomic.LoadInt64(&stats.downloaded)
+}
+
+func (stats *TransferStats) Left() int64 {
+	if left := atomic.LoadInt64(&stats.left); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// CountWrites wraps storage so every write counts as downloaded.
+func (stats *TransferStats) CountWrites(storage Storage) Storage {
+	return &countingStorage{Storage: storage, stats: stats}
+}
+
+type countingStorage struct {
+	Storage
+	stats *TransferStats
+}
+
+func (storage *countingStorage) WriteAt(p []byte, off int64) (int, error) {
+	n, err := storage.Storage.WriteAt(p, off)
+	storage.stats.AddDownloaded(int64(n))
+	return n, err
+}
+
+// Announcer keeps a torrent announced: `started` first, then on the
+// tracker's interval (sooner, down to min interval, while we know fewer
+// than MinPeers peers), `completed` once the download finishes and
+// `stopped` on Stop.
+type Announcer struct {
+	client *Client
+	meta   *TorrentFileMeta
+	stats  *TransferStats
+	// MinPeers is the peer count below which we re-announce early.
+	MinPeers int
+	// OnPeers, when set before Start, receives the peers of every periodic
+	// announce.
+	OnPeers func(result *PeersResult)
+	// announce is the tracker request, swapped out in tests.
+	announce func(trackerURL string, params *TrackerClientParams) (TrackerResponse, error)
+
+	mu            sync.Mutex
+	interval      time.Duration
+	minInterval   time.Duration
+	last          time.Time
+	failures      int
+	peerCount     int
+	running       bool
+	started       bool
+	completed     bool
+	completedSent bool
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (client *Client) NewAnnouncer(meta *TorrentFileMeta, stats *TransferStats) *Announcer {
+	return &Announcer{
+		client:   client,
+		meta:     meta,
+		stats:    stats,
+		MinPeers: defaultMinPeers,
+		announce: announce,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (announcer *Announcer) params(event AnnounceEvent) *TrackerClientParams {
+	return &TrackerClientParams{
+		InfoHash:   string(announcer.meta.InfoHashBytes),
+		PeerId:     announcer.client.PeerId,
+		Port:       strconv.Itoa(announcer.client.Port),
+		Uploaded:   strconv.FormatInt(announcer.stats.Uploaded(), 10),
+		Downloaded: strconv.FormatInt(announcer.stats.Downloaded(), 10),
+		Left:       strconv.FormatInt(announcer.stats.Left(), 10),
+		Compact:    "1",
+		Event:      string(event),
+	}
+}
+
+// Start sends `started` and returns its peers, then keeps announcing in the
+// background until Stop. A failed start is retried by the background loop.
+func (announcer *Announcer) Start() (*PeersResult, error) {
+	result, err := announcer.send(EventStarted)
+	announcer.mu.Lock()
+	announcer.running = true
+	announcer.mu.Unlock()
+	go announcer.run()
+	return result, err
+}
+
+// Completed reports that the download finished. The `completed` event is
+// only sent when the torrent was incomplete when we started.
+func (announcer *Announcer) Completed() {
+	announcer.mu.Lock()
+	announcer.completed = true
+	announcer.mu.Unlock()
+	announcer.poke()
+}
+
+// SetPeerCount tells the announcer how many peers we have, so it can
+// re-announce early when that drops below MinPeers. Until it is called the
+// count is zero.
+func (announcer *Announcer) SetPeerCount(n int) {
+	announcer.mu.Lock()
+	announcer.peerCount = n
+	announcer.mu.Unlock()
+	announcer.poke()
+}
+
+func (announcer *Announcer) poke() {
+	select {
+	case announcer.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop sends any pending `completed`, then `stopped`, and waits for the
+// background loop to exit. It does nothing if Start was never called.
+func (announcer *Announcer) Stop() {
+	announcer.mu.Lock()
+	running := announcer.running
+	announcer.mu.Unlock()
+	if !running {
+		return
+	}
+	close(announcer.stop)
+	<-announcer.done
+}
+
+func (announcer *Announcer) run() {
+	defer close(announcer.done)
+	for {
+		timer := time.NewTimer(announcer.nextAnnounce())
+		select {
+		case <-announcer.stop:
+			timer.Stop()
+			announcer.sendCompleted()
+			announcer.mu.Lock()
+			started := announcer.started
+			announcer.mu.Unlock()
+			if started {
+				announcer.send(EventStopped)
+			}
+			return
+		case <-announcer.wake:
+			timer.Stop()
+			announcer.sendCompleted()
+		case <-timer.C:
+			event := EventNone
+			announcer.mu.Lock()
+			if !announcer.started {
+				event = EventStarted
+			}
+			announcer.mu.Unlock()
+			result, err := announcer.send(event)
+			if err == nil && announcer.OnPeers != nil {
+				announcer.OnPeers(result)
+			}
+		}
+	}
+}
+
+func (announcer *Announcer) sendCompleted() {
+	announcer.mu.Lock()
+	pending := announcer.started && announcer.completed && !announcer.completedSent
+	announcer.mu.Unlock()
+	if pending {
+		announcer.send(EventCompleted)
+	}
+}
+
+// nextAnnounce is how long until the next regular announce is due.
+func (announcer *Announcer) nextAnnounce() time.Duration {
+	announcer.mu.Lock()
+	defer announcer.mu.Unlock()
+	interval, minInterval := announcer.interval, announcer.minInterval
+	if interval <= 0 {
+		interval = defaultAnnounceInterval
+	}
+	if minInterval <= 0 {
+		minInterval = defaultMinAnnounceInterval
+	}
+	wait := interval
+	switch {
+	case announcer.failures > 0:
+		wait = announceRetry << uint(announcer.failures-1)
+		if wait > interval || wait <= 0 {
+			wait = interval
+		}
+	case announcer.peerCount < announcer.MinPeers:
+		wait = minInterval
+	}
+	if wait < minInterval && announcer.failures == 0 {
+		wait = minInterval
+	}
+	return time.Until(announcer.last.Add(wait))
+}
+
+// send announces event to the first tracker that answers and records the
+// intervals it asks for.
+func (announcer *Announcer) send(event AnnounceEvent) (*PeersResult, error) {
+	params := announcer.params(event)
+	trackerResp, trackerURL, err := announcer.client.Trackers.Announce(func(trackerURL string) (TrackerResponse, error) {
+		return announcer.announce(trackerURL, params)
+	})
+
+	announcer.mu.Lock()
+	defer announcer.mu.Unlock()
+	announcer.last = time.Now()
+	if err != nil {
+		announcer.failures++
+		fmt.Printf("Announce %s failed: %s\n", eventName(event), err)
+		return &PeersResult{}, err
+	}
+	announcer.failures = 0
+	announcer.interval = time.Duration(trackerResp.Interval) * time.Second
+	announcer.minInterval = time.Duration(trackerResp.MinInterval) * time.Second
+	switch event {
+	case EventStarted:
+		announcer.started = true
+		// nothing was left to download, so there is nothing to complete
+		announcer.completedSent = params.Left == "0"
+	case EventCompleted:
+		announcer.completedSent = true
+	}
+	peers := append(trackerResp.Peers.Strings(), CompactPeers(trackerResp.Peers6).Strings()...)
+	fmt.Printf("Announced %s to %s: %d peers, interval %ds\n", eventName(event), trackerURL, len(peers), trackerResp.Interval)
+	return &PeersResult{
+		Interval: trackerResp.Interval,
+		Peers:    announcer.client.FilterPeers(PeerSourceTracker, peers),
+	}, nil
+}
+
+func eventName(event AnnounceEvent) string {
+	if event == EventNone {
+		return "update"
+	}
+	return string(event)
+}
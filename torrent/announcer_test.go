@@ -0,0 +1,134 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testAnnouncer(t *testing.T, left int64, announce func(trackerURL string, params *TrackerClientParams) (TrackerResponse, error)) (*Announcer, *TransferStats) {
+	t.Helper()
+	meta, err := ParseTorrent(testTorrent(t, 10, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(meta, &Config{PeerId: "-XX0000-000000000000", Port: 6881})
+	stats := NewTransferStats(left)
+	announcer := client.NewAnnouncer(meta, stats)
+	announcer.announce = announce
+	return announcer, stats
+}
+
+func testPeers(n int) CompactPeers {
+	var peers CompactPeers
+	for i := 0; i < n; i++ {
+		peers = append(peers, Peer{IP: net.IPv4(10, 0, 0, byte(i+1)), Port: 6881})
+	}
+	return peers
+}
+
+func TestAnnouncerEvents(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	announcer, stats := testAnnouncer(t, 10, func(trackerURL string, params *TrackerClientParams) (TrackerResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, params.Event)
+		return TrackerResponse{Interval: 1800, Peers: testPeers(10)}, nil
+	})
+	if _, err := announcer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	stats.AddDownloaded(10)
+	announcer.Completed()
+	announcer.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"started", "completed", "stopped"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events %q, want %q", events, want)
+	}
+}
+
+func TestAnnouncerSkipsCompletedWhenSeeding(t *testing.T) {
+	var events []string
+	announcer, _ := testAnnouncer(t, 0, func(trackerURL string, params *TrackerClientParams) (TrackerResponse, error) {
+		events = append(events, params.Event)
+		return TrackerResponse{Interval: 1800}, nil
+	})
+	announcer.send(EventStarted)
+	announcer.Completed()
+	announcer.sendCompleted()
+	want := []string{"started"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events %q, want %q", events, want)
+	}
+}
+
+func TestAnnouncerSchedule(t *testing.T) {
+	var response TrackerResponse
+	var failure error
+	announcer, _ := testAnnouncer(t, 10, func(trackerURL string, params *TrackerClientParams) (TrackerResponse, error) {
+		return response, failure
+	})
+	check := func(name string, want time.Duration) {
+		t.Helper()
+		if got := announcer.nextAnnounce(); got < want-time.Second || got > want {
+			t.Errorf("%s: next announce in %s, want %s", name, got, want)
+		}
+	}
+
+	// the count is what the download reports, not what the tracker returned
+	response = TrackerResponse{Interval: 1800, MinInterval: 60, Peers: testPeers(10)}
+	announcer.send(EventStarted)
+	check("no peers reported", time.Minute)
+
+	announcer.SetPeerCount(10)
+	check("enough peers", 30*time.Minute)
+
+	response.Peers = testPeers(2)
+	announcer.send(EventNone)
+	check("tracker returned few peers", 30*time.Minute)
+
+	announcer.SetPeerCount(1)
+	check("peers dropped", time.Minute)
+	announcer.SetPeerCount(10)
+
+	response = TrackerResponse{Interval: 10, MinInterval: 60, Peers: testPeers(10)}
+	announcer.send(EventNone)
+	check("interval below min interval", time.Minute)
+
+	response = TrackerResponse{Interval: 1800, MinInterval: 60, Peers: testPeers(10)}
+	announcer.send(EventNone)
+	failure = errors.New("tracker down")
+	for failures, want := range []time.Duration{15 * time.Second, 30 * time.Second, time.Minute, 2 * time.Minute} {
+		announcer.send(EventNone)
+		check(fmt.Sprintf("%d failures", failures+1), want)
+	}
+	for i := 0; i < 10; i++ {
+		announcer.send(EventNone)
+	}
+	check("back-off capped at interval", 30*time.Minute)
+}
+
+func TestAnnouncerStopWithoutStart(t *testing.T) {
+	announcer, _ := testAnnouncer(t, 10, func(trackerURL string, params *TrackerClientParams) (TrackerResponse, error) {
+		t.Errorf("announced %q without Start", params.Event)
+		return TrackerResponse{}, nil
+	})
+	stopped := make(chan struct{})
+	go func() {
+		announcer.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop blocked without Start")
+	}
+}
@@ -0,0 +1,31 @@
+package torrent
+
+import "encoding/hex"
+
+// Bitfield is a piece bitmap as sent in the wire `bitfield` message: the high
+// bit of the first byte is piece 0.
+type Bitfield []byte
+
+func NewBitfield(numPieces int) Bitfield {
+	return make(Bitfield, (numPieces+7)/8)
+}
+
+func (bitfield Bitfield) Has(index int) bool {
+	byteIndex := index / 8
+	if index < 0 || byteIndex >= len(bitfield) {
+		return false
+	}
+	return bitfield[byteIndex]>>(7-uint(index%8))&1 != 0
+}
+
+func (bitfield Bitfield) Set(index int) {
+	byteIndex := index / 8
+	if index < 0 || byteIndex >= len(bitfield) {
+		return
+	}
+	bitfield[byteIndex] |= 1 << (7 - uint(index%8))
+}
+
+func (bitfield Bitfield) String() string {
+	return hex.EncodeToString(bitfield)
+}
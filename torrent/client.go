@@ -2,16 +2,15 @@ package torrent
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net"
-	"net/http"
-
-	"github.com/dghubble/sling"
-	bencode "github.com/jackpal/bencode-go"
+	"sync"
+	"time"
 )
 
 const (
@@ -25,6 +24,13 @@ const (
 	BlockSize int64 = 16 * 1024
 )
 
+const (
+	// peerDialTimeout and peerIOTimeout keep a dead or silent peer from
+	// stalling a download.
+	peerDialTimeout = 10 * time.Second
+	peerIOTimeout   = 30 * time.Second
+)
+
 type Config struct {
 	PeerId string
 	Port   int
@@ -32,14 +38,16 @@ type Config struct {
 type Client struct {
 	Meta *TorrentFileMeta
 	*Config
-	Conns map[string]net.Conn
+	Conns    map[string]net.Conn
+	Trackers *TrackerTiers
 }
 
 func NewClient(meta *TorrentFileMeta, config *Config) *Client {
 	return &Client{
-		Meta:   meta,
-		Config: config,
-		Conns:  make(map[string]net.Conn),
+		Meta:     meta,
+		Config:   config,
+		Conns:    make(map[string]net.Conn),
+		Trackers: NewTrackerTiers(meta),
 	}
 }
 
@@ -57,69 +65,50 @@ type PeersResponse struct {
 	RawPeers string `json:"peers"`
 }
 
-func ParsePeers(rawPeers string) []string {
-	peers := make([]string, 0, 10)
-	PEER_SIZE := 6
-	buf := []byte(rawPeers)
-	for index := 0; index+PEER_SIZE <= len(buf); index += PEER_SIZE {
-		a := buf[index+0]
-		b := buf[index+1]
-		c := buf[index+2]
-		d := buf[index+3]
-		portBytes := []byte{0, 0, 0, 0, 0, 0, buf[index+4], buf[index+5]}
-		port := binary.BigEndian.Uint64(portBytes)
-		ip := fmt.Sprintf("%d.%d.%d.%d:%d", a, b, c, d, port)
-		peers = append(peers, ip)
-	}
-	return peers
-}
-
 type PeersResult struct {
 	Interval int
 	Peers    []string
 }
 
 func (client *Client) RequestPeers(meta *TorrentFileMeta) (*PeersResult, error) {
-	params := DefaultTrackerClientParams(string(meta.InfoHashBytes),
-		meta.TorrentFileInfo.Info.Length)
-	req, err := sling.New().Get(meta.TorrentFileInfo.Announce).QueryStruct(params).Request()
-	if err != nil {
-		return &PeersResult{}, err
-	}
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		fmt.Printf("client: error making http request: %s\n", err)
-		return &PeersResult{}, err
-	}
-	defer res.Body.Close()
-	resBody, err := io.ReadAll(res.Body)
+	params := DefaultTrackerClientParams(string(meta.InfoHashBytes), meta.TotalLength())
+	trackerResp, _, err := client.Trackers.Announce(func(trackerURL string) (TrackerResponse, error) {
+		return announce(trackerURL, params)
+	})
 	if err != nil {
-		fmt.Printf("client: could not read response body: %s\n", err)
-		return &PeersResult{}, err
-	}
-	// fmt.Printf("client: response body: %s\n", resBody)
-
-	var trackerResp TrackerResponse
-	if err := bencode.Unmarshal(bytes.NewReader(resBody), &trackerResp); err != nil {
 		return &PeersResult{}, err
 	}
-	peers := ParsePeers(trackerResp.Peers)
+	peers := append(trackerResp.Peers.Strings(), CompactPeers(trackerResp.Peers6).Strings()...)
 	return &PeersResult{
 		Interval: trackerResp.Interval,
-		Peers:    peers,
+		Peers:    client.FilterPeers(PeerSourceTracker, peers),
 	}, nil
 }
 
 func (client *Client) Dial(peerAddress string) error {
-	conn, err := net.Dial("tcp", peerAddress)
+	conn, err := net.DialTimeout("tcp", peerAddress, peerDialTimeout)
 	if err != nil {
 		return err
 	}
-	client.Conns[peerAddress] = conn
+	client.Conns[peerAddress] = &timeoutConn{Conn: conn}
 	return nil
 }
 
+// timeoutConn gives every read and write peerIOTimeout to complete.
+type timeoutConn struct {
+	net.Conn
+}
+
+func (conn *timeoutConn) Read(p []byte) (int, error) {
+	conn.Conn.SetReadDeadline(time.Now().Add(peerIOTimeout))
+	return conn.Conn.Read(p)
+}
+
+func (conn *timeoutConn) Write(p []byte) (int, error) {
+	conn.Conn.SetWriteDeadline(time.Now().Add(peerIOTimeout))
+	return conn.Conn.Write(p)
+}
+
 func (client *Client) Close(peerAddress string) {
 	conn, ok := client.Conns[peerAddress]
 	if !ok {
@@ -129,9 +118,19 @@ func (client *Client) Close(peerAddress string) {
 }
 
 func (client *Client) Handshake(peerAddress string, infohash []byte) (string, error) {
+	reply, err := client.handshake(peerAddress, infohash, [8]byte{})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(reply.PeerId[:]), nil
+}
+
+// handshake exchanges handshake messages advertising the given reserved bits
+// and returns the peer's reply.
+func (client *Client) handshake(peerAddress string, infohash []byte, reserved [8]byte) (*PeerHandshakeMessage, error) {
 	conn, ok := client.Conns[peerAddress]
 	if !ok {
-		return "", fmt.Errorf("no connection with peer address: %s", peerAddress)
+		return nil, fmt.Errorf("no connection with peer address: %s", peerAddress)
 	}
 
 	var buffer bytes.Buffer
@@ -143,45 +142,56 @@ func (client *Client) Handshake(peerAddress string, infohash []byte) (string, er
 	peerHandshakeMessageRequest := &PeerHandshakeMessage{
 		ProtocolLength: 19,
 		Protocol:       [19]byte{'B', 'i', 't', 'T', 'o', 'r', 'r', 'e', 'n', 't', ' ', 'p', 'r', 'o', 't', 'o', 'c', 'o', 'l'},
-		Reserved:       [8]byte{0, 0, 0, 0, 0, 0, 0, 0},
+		Reserved:       reserved,
 		InfoHash:       infoHashBytesArray,
 		PeerId:         peerIdBytesArray,
 	}
 	err := binary.Write(&buffer, binary.BigEndian, peerHandshakeMessageRequest)
 	if err != nil {
-		return "", fmt.Errorf("unable to write peer handshake message to buffer: %w", err)
+		return nil, fmt.Errorf("unable to write peer handshake message to buffer: %w", err)
 	}
 	_, err = conn.Write(buffer.Bytes())
 	if err != nil {
-		return "", fmt.Errorf("unable to write handshake message to peer %s: %w", peerAddress, err)
+		return nil, fmt.Errorf("unable to write handshake message to peer %s: %w", peerAddress, err)
 	}
-	reply := make([]byte, 48+20)
-	_, err = conn.Read(reply)
-	if err != nil {
-		return "", err
+	var reply PeerHandshakeMessage
+	if err := binary.Read(conn, binary.BigEndian, &reply); err != nil {
+		return nil, fmt.Errorf("unable to read handshake message from peer %s: %w", peerAddress, err)
 	}
-	peerId := reply[48 : 48+20]
-
-	return hex.EncodeToString(peerId), nil
+	if reply.InfoHash != infoHashBytesArray {
+		return nil, fmt.Errorf("peer %s replied with info hash %x", peerAddress, reply.InfoHash)
+	}
+	return &reply, nil
 }
 
+// maxMessageLength bounds the buffer a peer can make us allocate. It fits a
+// full block plus headers and the bitfield of any reasonable torrent.
+const maxMessageLength = 2 << 20
+
 func (client *Client) RecieveMessage(peerAddress string) (byte, []byte, error) {
 	conn, ok := client.Conns[peerAddress]
 	if !ok {
 		return 0, nil, fmt.Errorf("no connection with peer address: %s", peerAddress)
 	}
 	lengthBytes := make([]byte, 4)
-	if _, err := conn.Read(lengthBytes); err != nil {
-		return 0, nil, err
+	var length uint32
+	for length == 0 {
+		if _, err := io.ReadFull(conn, lengthBytes); err != nil {
+			return 0, nil, err
+		}
+		// zero length is a keep-alive
+		length = binary.BigEndian.Uint32(lengthBytes)
+	}
+	if length > maxMessageLength {
+		return 0, nil, fmt.Errorf("message of %d bytes from %s exceeds limit", length, peerAddress)
 	}
-	length := binary.BigEndian.Uint32(lengthBytes)
 	messageType := make([]byte, 1)
-	if _, err := conn.Read(messageType); err != nil {
+	if _, err := io.ReadFull(conn, messageType); err != nil {
 		return 0, nil, err
 	}
 	length--
 	message := make([]byte, length)
-	if _, err := io.ReadAtLeast(conn, message, int(length)); err != nil {
+	if _, err := io.ReadFull(conn, message); err != nil {
 		return 0, nil, err
 	}
 	fmt.Println("recieve", messageType[0], len(message))
@@ -230,7 +240,7 @@ func (client *Client) SendInterested(peerAddress string) error {
 	return client.SendMessage(peerAddress, MessageIntereseted, []byte{})
 }
 
-func (client *Client) SendRequest(peerAddress string, pieceIndex int, begin, length int) error {
+func (client *Client) SendRequest(peerAddress string, pieceIndex int, begin, length int64) error {
 	message := make([]byte, 12)
 	binary.BigEndian.PutUint32(message[0:4], uint32(pieceIndex))
 	binary.BigEndian.PutUint32(message[4:8], uint32(begin))
@@ -253,63 +263,101 @@ func (client *Client) RecievePiece(peerAddress string) (uint32, uint32, []byte,
 }
 
 func (client *Client) DownloadPiece(meta *TorrentFileMeta, peerAddress string, pieceIndex int) ([]byte, error) {
+	if err := meta.checkPieceIndex(pieceIndex); err != nil {
+		return nil, err
+	}
+	if err := client.startDownload(meta, peerAddress); err != nil {
+		return nil, err
+	}
+	defer client.Close(peerAddress)
+
+	pieceSize := meta.PieceSize(pieceIndex)
+	fmt.Printf("[RequestPiece] - Piece Length: %d - Length: %d - Piece Index: %d\n", pieceSize, meta.TotalLength(), pieceIndex)
+	return client.requestBlocks(peerAddress, pieceIndex, pieceSize, nil)
+}
+
+// startDownload connects to peerAddress and waits until it unchokes us. The
+// connection is left open for the caller to close.
+func (client *Client) startDownload(meta *TorrentFileMeta, peerAddress string) error {
 	fmt.Printf("Connecting to %s...\n", peerAddress)
 	if err := client.Dial(peerAddress); err != nil {
 		fmt.Println(err)
-		return nil, err
+		return err
 	}
-	defer client.Close(peerAddress)
 
 	fmt.Println("Sending handshake...")
-	_, err := client.Handshake(peerAddress, meta.InfoHashBytes)
+	var reserved [8]byte
+	if meta.IsV2() {
+		reserved[v2ReservedByte] |= v2ReservedBit
+	}
+	_, err := client.handshake(peerAddress, meta.InfoHashBytes, reserved)
 	if err != nil {
 		fmt.Println(err)
-		return nil, err
+		client.Close(peerAddress)
+		return err
 	}
 	fmt.Println("Handshake is successful")
 	fmt.Println("Waiting for 'bitfield'...")
 	if _, err := client.RecieveBitfield(peerAddress); err != nil {
 		fmt.Println(err)
-		return nil, err
+		client.Close(peerAddress)
+		return err
 	}
 	fmt.Println("Recieved 'bitfield'...")
 	fmt.Println("Sending 'interested'")
 	if err := client.SendInterested(peerAddress); err != nil {
 		fmt.Println(err)
-		return nil, err
+		client.Close(peerAddress)
+		return err
 	}
 	fmt.Println("Sent 'interested'")
 	fmt.Println("Wating for 'unchoke'...")
 	if err := client.RecieveUnchoke(peerAddress); err != nil {
 		fmt.Println(err)
-		return nil, err
+		client.Close(peerAddress)
+		return err
 	}
 	fmt.Println("Recieved 'unchoke'")
+	return nil
+}
+
+// DownloadPieceV2 downloads piece index of a v2 file, checking every block
+// against its merkle leaf as it arrives. Piece layers missing from meta are
+// fetched from the peer first.
+func (client *Client) DownloadPieceV2(meta *TorrentFileMeta, peerAddress string, file FileEntry, index, pieceIndex int) ([]byte, error) {
+	if index < 0 || index >= meta.V2PieceCount(file) {
+		return nil, fmt.Errorf("piece index %d out of range [0, %d)", index, meta.V2PieceCount(file))
+	}
+	if err := client.startDownload(meta, peerAddress); err != nil {
+		return nil, err
+	}
+	defer client.Close(peerAddress)
+
+	leaves, err := client.blockLeaves(peerAddress, meta, file, index)
+	if err != nil {
+		return nil, err
+	}
+	pieceSize := meta.PieceLength()
+	if remaining := file.Length - int64(index)*meta.PieceLength(); remaining < pieceSize {
+		pieceSize = remaining
+	}
+	fmt.Printf("[RequestPiece] - %s piece %d - Piece Length: %d - Piece Index: %d\n", file, index, pieceSize, pieceIndex)
+	return client.requestBlocks(peerAddress, pieceIndex, pieceSize, leaves)
+}
 
-	pieceLength := client.Meta.TorrentFileInfo.Info.PieceLength
-	length := client.Meta.TorrentFileInfo.Info.Length
-	// last not whole piece
-	if pieceIndex >= int(length/pieceLength) {
-		pieceLength = length - (pieceLength * pieceIndex)
-	}
-	fmt.Printf("[RequestPiece] - Piece Length: %d - Length: %d - Piece Index: %d\n", pieceLength, length, pieceIndex)
-	data := make([]byte, pieceLength)
-	lastBlockSize := int64(pieceLength) % BlockSize
-	piecesNum := (int64(pieceLength) - lastBlockSize) / BlockSize
-	if lastBlockSize > 0 {
-		piecesNum++
-	}
-	fmt.Printf("[requestPiece] - Piece Length: %d # of Pieces: %d\n", pieceLength, piecesNum)
-	for i := int64(0); i < int64(pieceLength); i += int64(BlockSize) {
+// requestBlocks requests a piece block by block. When leaves is set, each
+// block must match its SHA-256 merkle leaf.
+func (client *Client) requestBlocks(peerAddress string, pieceIndex int, pieceSize int64, leaves [][MerkleHashSize]byte) ([]byte, error) {
+	data := make([]byte, pieceSize)
+	blocksNum := (pieceSize + BlockSize - 1) / BlockSize
+	fmt.Printf("[requestPiece] - Piece Length: %d # of Blocks: %d\n", pieceSize, blocksNum)
+	for begin := int64(0); begin < pieceSize; begin += BlockSize {
 		length := BlockSize
-		if i+int64(BlockSize) > int64(pieceLength) {
-			fmt.Printf("reached last block, changing size to %d\n", lastBlockSize)
-			length = int64(pieceLength) - i
-			if length > BlockSize {
-				length = BlockSize
-			}
+		if begin+length > pieceSize {
+			length = pieceSize - begin
+			fmt.Printf("reached last block, changing size to %d\n", length)
 		}
-		if err := client.SendRequest(peerAddress, pieceIndex, int(i), int(length)); err != nil {
+		if err := client.SendRequest(peerAddress, pieceIndex, begin, length); err != nil {
 			return nil, err
 		}
 		recievedPieceIndex, recievedBegin, recievedBlock, err := client.RecievePiece(peerAddress)
@@ -319,6 +367,15 @@ func (client *Client) DownloadPiece(meta *TorrentFileMeta, peerAddress string, p
 		if recievedPieceIndex != uint32(pieceIndex) {
 			return nil, errors.New("mismatched piece index")
 		}
+		if int64(recievedBegin)+int64(len(recievedBlock)) > pieceSize {
+			return nil, fmt.Errorf("block at %d with length %d overruns piece of %d bytes", recievedBegin, len(recievedBlock), pieceSize)
+		}
+		if leaves != nil {
+			leaf := int64(recievedBegin) / MerkleBlockSize
+			if int64(recievedBegin)%MerkleBlockSize != 0 || leaf >= int64(len(leaves)) || sha256.Sum256(recievedBlock) != leaves[leaf] {
+				return nil, fmt.Errorf("block at %d of piece %d fails merkle verification", recievedBegin, pieceIndex)
+			}
+		}
 		copy(data[recievedBegin:], recievedBlock)
 	}
 	return data, nil
@@ -340,24 +397,163 @@ func SplitBytes(input []byte, chunkSize int) [][]byte {
 	return result
 }
 
-func (client *Client) DownloadFile(meta *TorrentFileMeta, peerAddress string) ([]byte, error) {
+// PiecePeer is anything pieces can be downloaded from: a BitTorrent peer or
+// a web seed. FetchPiece returns piece index, as numbered by pieceSpans,
+// already verified.
+type PiecePeer interface {
+	FetchPiece(meta *TorrentFileMeta, index int) ([]byte, error)
+	String() string
+}
+
+type tcpPeer struct {
+	client  *Client
+	address string
+}
+
+// TCPPeer wraps a BitTorrent peer as a PiecePeer.
+func (client *Client) TCPPeer(peerAddress string) PiecePeer {
+	return &tcpPeer{client: client, address: peerAddress}
+}
+
+func (peer *tcpPeer) String() string {
+	return peer.address
+}
+
+func (peer *tcpPeer) FetchPiece(meta *TorrentFileMeta, index int) ([]byte, error) {
+	layout, err := meta.pieceLayout()
+	if err != nil {
+		return nil, err
+	}
+	span, err := layout.span(index)
+	if err != nil {
+		return nil, err
+	}
+	if !meta.IsV1() {
+		// blocks are checked against their merkle leaves as they arrive
+		return peer.client.DownloadPieceV2(meta, peer.address, layout.files[span.file], span.fileIndex, index)
+	}
+	piece, err := peer.client.DownloadPiece(meta, peer.address, index)
+	if err != nil {
+		return nil, err
+	}
+	if err := meta.verifySpan(layout.files, span, index, piece); err != nil {
+		return nil, fmt.Errorf("piece %d from %s: %w", index, peer.address, err)
+	}
+	return piece, nil
+}
+
+// PeerList is the set of swarm peers a download tries. It grows as trackers
+// return more peers and shrinks as peers fail, and is safe for concurrent
+// use.
+type PeerList struct {
+	// OnChange, when set, receives the number of peers after every change.
+	OnChange func(n int)
+
+	mu    sync.Mutex
+	peers []PiecePeer
+}
 
-	data := make([]byte, meta.TorrentFileInfo.Info.Length)
-	bytesChunk := SplitBytes([]byte(meta.TorrentFileInfo.Info.Pieces), 20)
-	fmt.Printf("Length: %d pieceLength: %d \n",
-		meta.TorrentFileInfo.Info.Length,
-		meta.TorrentFileInfo.Info.PieceLength)
-	for index := range bytesChunk {
-		startIndex := index * meta.TorrentFileInfo.Info.PieceLength
-		fmt.Printf("pieceIndex: %d byteIndex: %d\n", index, startIndex)
-		if startIndex >= meta.TorrentFileInfo.Info.Length {
-			startIndex = meta.TorrentFileInfo.Info.Length - (startIndex-1)*meta.TorrentFileInfo.Info.PieceLength
+func NewPeerList(peers ...PiecePeer) *PeerList {
+	list := &PeerList{}
+	list.Add(peers...)
+	return list
+}
+
+// Add appends the peers not in the list yet, by address.
+func (list *PeerList) Add(peers ...PiecePeer) {
+	list.mu.Lock()
+	added := false
+	for _, peer := range peers {
+		if list.index(peer) < 0 {
+			list.peers = append(list.peers, peer)
+			added = true
+		}
+	}
+	n := len(list.peers)
+	list.mu.Unlock()
+	if added && list.OnChange != nil {
+		list.OnChange(n)
+	}
+}
+
+func (list *PeerList) Remove(peer PiecePeer) {
+	list.mu.Lock()
+	i := list.index(peer)
+	if i >= 0 {
+		list.peers = append(list.peers[:i:i], list.peers[i+1:]...)
+	}
+	n := len(list.peers)
+	list.mu.Unlock()
+	if i >= 0 && list.OnChange != nil {
+		list.OnChange(n)
+	}
+}
+
+func (list *PeerList) index(peer PiecePeer) int {
+	for i, known := range list.peers {
+		if known.String() == peer.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+// Peers returns a copy of the current peers.
+func (list *PeerList) Peers() []PiecePeer {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	return append([]PiecePeer(nil), list.peers...)
+}
+
+func (list *PeerList) Len() int {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	return len(list.peers)
+}
+
+// isConnectionError reports whether err means the peer can't be reached or
+// dropped us, rather than that one piece went wrong.
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// DownloadPieces downloads every piece into storage, trying the swarm peers
+// and then the seeds for each piece until one of them delivers it. A peer
+// whose connection fails is dropped from peers for the rest of the download.
+func DownloadPieces(meta *TorrentFileMeta, peers *PeerList, seeds []PiecePeer, storage Storage) error {
+	if peers.Len() == 0 && len(seeds) == 0 {
+		return fmt.Errorf("no peers to download from")
+	}
+	fmt.Printf("Length: %d pieceLength: %d \n", meta.TotalLength(), meta.PieceLength())
+	spans, err := meta.pieceSpans()
+	if err != nil {
+		return err
+	}
+	for index, span := range spans {
+		fmt.Printf("pieceIndex: %d byteIndex: %d\n", index, span.offset)
+		var piece []byte
+		err := fmt.Errorf("no peers or seeds left for piece %d", index)
+		for _, peer := range append(peers.Peers(), seeds...) {
+			if piece, err = peer.FetchPiece(meta, index); err == nil {
+				break
+			}
+			fmt.Printf("piece %d from %s failed: %s\n", index, peer, err)
+			if _, ok := peer.(*tcpPeer); ok && isConnectionError(err) {
+				peers.Remove(peer)
+			}
 		}
-		piece, err := client.DownloadPiece(meta, peerAddress, index)
 		if err != nil {
-			return []byte{}, err
+			return err
+		}
+		if _, err := storage.WriteAt(piece, span.offset); err != nil {
+			return err
 		}
-		copy(data[startIndex:], piece)
 	}
-	return data, nil
+	return nil
+}
+
+// DownloadFile downloads every piece from peerAddress into storage.
+func (client *Client) DownloadFile(meta *TorrentFileMeta, peerAddress string, storage Storage) error {
+	return DownloadPieces(meta, NewPeerList(client.TCPPeer(peerAddress)), nil, storage)
 }
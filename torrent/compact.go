@@ -0,0 +1,190 @@
+package torrent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+// Peer is a peer's transport address.
+type Peer struct {
+	IP   net.IP
+	Port uint16
+}
+
+func (peer Peer) String() string {
+	return net.JoinHostPort(peer.IP.String(), strconv.Itoa(int(peer.Port)))
+}
+
+func parseCompactPeer(b []byte) Peer {
+	ipLength := len(b) - 2
+	ip := make(net.IP, ipLength)
+	copy(ip, b[:ipLength])
+	return Peer{IP: ip, Port: binary.BigEndian.Uint16(b[ipLength:])}
+}
+
+func appendCompactPeer(buf []byte, peer Peer, ipLength int) ([]byte, error) {
+	ip := peer.IP.To16()
+	if ipLength == net.IPv4len {
+		ip = peer.IP.To4()
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("peer %s cannot be encoded with a %d-byte address", peer, ipLength)
+	}
+	buf = append(buf, ip...)
+	return append(buf, byte(peer.Port>>8), byte(peer.Port)), nil
+}
+
+// CompactPeers is the tracker `peers` value. It decodes both the compact
+// form (BEP 23, 6 bytes per IPv4 peer) and the original list of dictionaries.
+type CompactPeers []Peer
+
+func (peers *CompactPeers) UnmarshalBencode(data []byte) error {
+	if len(data) > 0 && data[0] == 'l' {
+		var dicts []struct {
+			IP   string `bencode:"ip"`
+			Port uint16 `bencode:"port"`
+		}
+		if err := bencode.Unmarshal(data, &dicts); err != nil {
+			return err
+		}
+		*peers = make(CompactPeers, 0, len(dicts))
+		for _, dict := range dicts {
+			ip := net.ParseIP(dict.IP)
+			if ip == nil {
+				return fmt.Errorf("invalid peer ip %q", dict.IP)
+			}
+			*peers = append(*peers, Peer{IP: ip, Port: dict.Port})
+		}
+		return nil
+	}
+	parsed, err := unmarshalCompactPeers(data, net.IPv4len)
+	*peers = parsed
+	return err
+}
+
+func (peers CompactPeers) MarshalBencode() ([]byte, error) {
+	return marshalCompactPeers(peers, net.IPv4len)
+}
+
+func (peers CompactPeers) Strings() []string {
+	addresses := make([]string, len(peers))
+	for i, peer := range peers {
+		addresses[i] = peer.String()
+	}
+	return addresses
+}
+
+// CompactPeers6 is the tracker `peers6` value (BEP 7), 18 bytes per peer.
+type CompactPeers6 []Peer
+
+func (peers *CompactPeers6) UnmarshalBencode(data []byte) error {
+	parsed, err := unmarshalCompactPeers(data, net.IPv6len)
+	*peers = CompactPeers6(parsed)
+	return err
+}
+
+func (peers CompactPeers6) MarshalBencode() ([]byte, error) {
+	return marshalCompactPeers(CompactPeers(peers), net.IPv6len)
+}
+
+func unmarshalCompactPeers(data []byte, ipLength int) (CompactPeers, error) {
+	raw, err := bencode.UnmarshalString(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCompactPeers(raw, ipLength)
+}
+
+func decodeCompactPeers(raw []byte, ipLength int) (CompactPeers, error) {
+	entrySize := ipLength + 2
+	if len(raw)%entrySize != 0 {
+		return nil, fmt.Errorf("compact peer list length %d is not a multiple of %d", len(raw), entrySize)
+	}
+	peers := make(CompactPeers, 0, len(raw)/entrySize)
+	for offset := 0; offset < len(raw); offset += entrySize {
+		peers = append(peers, parseCompactPeer(raw[offset:offset+entrySize]))
+	}
+	return peers, nil
+}
+
+func marshalCompactPeers(peers CompactPeers, ipLength int) ([]byte, error) {
+	buf := make([]byte, 0, len(peers)*(ipLength+2))
+	for _, peer := range peers {
+		var err error
+		if buf, err = appendCompactPeer(buf, peer, ipLength); err != nil {
+			return nil, err
+		}
+	}
+	return bencode.MarshalString(buf), nil
+}
+
+// PieceHashes is the info dictionary's `pieces` value: concatenated SHA-1
+// hashes, one per piece.
+type PieceHashes [][PieceHashSize]byte
+
+func (hashes *PieceHashes) UnmarshalBencode(data []byte) error {
+	raw, err := bencode.UnmarshalString(data)
+	if err != nil {
+		return err
+	}
+	if len(raw)%PieceHashSize != 0 {
+		return fmt.Errorf("pieces length %d is not a multiple of %d", len(raw), PieceHashSize)
+	}
+	*hashes = make(PieceHashes, len(raw)/PieceHashSize)
+	for i := range *hashes {
+		copy((*hashes)[i][:], raw[i*PieceHashSize:])
+	}
+	return nil
+}
+
+func (hashes PieceHashes) MarshalBencode() ([]byte, error) {
+	buf := make([]byte, 0, len(hashes)*PieceHashSize)
+	for _, hash := range hashes {
+		buf = append(buf, hash[:]...)
+	}
+	return bencode.MarshalString(buf), nil
+}
+
+// Node is a DHT node: its 20-byte id and address.
+type Node struct {
+	ID   [20]byte
+	Addr Peer
+}
+
+// NodeList is compact node info (BEP 5), 26 bytes per IPv4 node.
+type NodeList []Node
+
+func (nodes *NodeList) UnmarshalBencode(data []byte) error {
+	raw, err := bencode.UnmarshalString(data)
+	if err != nil {
+		return err
+	}
+	const entrySize = 20 + net.IPv4len + 2
+	if len(raw)%entrySize != 0 {
+		return fmt.Errorf("compact node list length %d is not a multiple of %d", len(raw), entrySize)
+	}
+	*nodes = make(NodeList, 0, len(raw)/entrySize)
+	for offset := 0; offset < len(raw); offset += entrySize {
+		var node Node
+		copy(node.ID[:], raw[offset:offset+20])
+		node.Addr = parseCompactPeer(raw[offset+20 : offset+entrySize])
+		*nodes = append(*nodes, node)
+	}
+	return nil
+}
+
+func (nodes NodeList) MarshalBencode() ([]byte, error) {
+	buf := make([]byte, 0, len(nodes)*26)
+	for _, node := range nodes {
+		buf = append(buf, node.ID[:]...)
+		var err error
+		if buf, err = appendCompactPeer(buf, node.Addr, net.IPv4len); err != nil {
+			return nil, err
+		}
+	}
+	return bencode.MarshalString(buf), nil
+}
@@ -0,0 +1,195 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+const (
+	minAutoPieceLength = 16 * 1024
+	maxAutoPieceLength = 16 * 1024 * 1024
+	targetPieceCount   = 1500
+)
+
+type CreateOptions struct {
+	// Path is the file or directory to share.
+	Path string
+	// Name overrides the torrent name, which defaults to the base of Path.
+	Name string
+	// PieceLength overrides the automatic choice; it must be a power of two
+	// of at least 16 KiB.
+	PieceLength  int64
+	Announce     string
+	AnnounceList [][]string
+	Comment      string
+	CreatedBy    string
+	// CreationDate is left out of the torrent when zero.
+	CreationDate time.Time
+	Private      bool
+	Source       string
+	// Workers is the number of hashing goroutines, runtime.NumCPU() if zero.
+	Workers int
+	// Align inserts BEP 47 padding files so every file starts on a piece
+	// boundary.
+	Align bool
+}
+
+// AutoPieceLength picks the smallest power of two between 16 KiB and 16 MiB
+// that keeps the piece count near targetPieceCount.
+func AutoPieceLength(totalLength int64) int64 {
+	pieceLength := int64(minAutoPieceLength)
+	for pieceLength < maxAutoPieceLength && totalLength/pieceLength > targetPieceCount {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// CreateTorrent hashes the content at options.Path and returns its metainfo.
+// Encode the result with Marshal(meta.TorrentFileInfo).
+func CreateTorrent(options CreateOptions) (*TorrentFileMeta, error) {
+	stat, err := os.Stat(options.Path)
+	if err != nil {
+		return nil, err
+	}
+	info := TorrentFileInfo{
+		Name:    options.Name,
+		Private: options.Private,
+		Source:  options.Source,
+	}
+	if info.Name == "" {
+		info.Name = filepath.Base(filepath.Clean(options.Path))
+	}
+
+	var totalLength int64
+	if stat.IsDir() {
+		files, err := walkFiles(options.Path)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%s contains no files", options.Path)
+		}
+		info.Files = files
+		for _, file := range files {
+			totalLength += file.Length
+		}
+	} else {
+		info.Length = stat.Size()
+		totalLength = info.Length
+	}
+	if totalLength == 0 {
+		return nil, fmt.Errorf("%s has no content to hash", options.Path)
+	}
+
+	info.PieceLength = options.PieceLength
+	if info.PieceLength == 0 {
+		info.PieceLength = AutoPieceLength(totalLength)
+	}
+	if info.PieceLength < minAutoPieceLength || info.PieceLength&(info.PieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length %d must be a power of two of at least %d", info.PieceLength, minAutoPieceLength)
+	}
+	if options.Align && len(info.Files) > 0 {
+		info.Files = alignFiles(info.Files, info.PieceLength)
+		totalLength = 0
+		for _, file := range info.Files {
+			totalLength += file.Length
+		}
+	}
+	info.Pieces = make(PieceHashes, (totalLength+info.PieceLength-1)/info.PieceLength)
+
+	meta := &TorrentFileMeta{TorrentFileInfo: TorrentFile{Info: info}}
+	if err := meta.checkSizes(); err != nil {
+		return nil, err
+	}
+	storage, err := newFileStorage(meta, options.Path, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+	if err := hashPieces(meta, storage, options.Workers); err != nil {
+		return nil, err
+	}
+
+	rawInfo, err := bencode.Marshal(meta.TorrentFileInfo.Info)
+	if err != nil {
+		return nil, err
+	}
+	torrentFile := TorrentFile{
+		Announce:     options.Announce,
+		AnnounceList: options.AnnounceList,
+		Comment:      options.Comment,
+		CreatedBy:    options.CreatedBy,
+		Info:         meta.TorrentFileInfo.Info,
+		RawInfo:      rawInfo,
+	}
+	if !options.CreationDate.IsZero() {
+		torrentFile.CreationDate = options.CreationDate.Unix()
+	}
+	meta.TorrentFileInfo = torrentFile
+	meta.InfoHashBytes = torrentInfoHash(&torrentFile)
+	return meta, nil
+}
+
+// walkFiles lists regular files under root in a stable, sorted order.
+func walkFiles(root string) ([]FileInfo, error) {
+	var files []FileInfo
+	err := filepath.Walk(root, func(path string, stat os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !stat.Mode().IsRegular() {
+			return nil
+		}
+		relative, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, FileInfo{
+			Length: stat.Size(),
+			Path:   strings.Split(filepath.ToSlash(relative), "/"),
+		})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return strings.Join(files[i].Path, "/") < strings.Join(files[j].Path, "/")
+	})
+	return files, err
+}
+
+// alignFiles pads each file but the last up to a multiple of pieceLength.
+func alignFiles(files []FileInfo, pieceLength int64) []FileInfo {
+	var aligned []FileInfo
+	var offset int64
+	for i, file := range files {
+		aligned = append(aligned, file)
+		offset += file.Length
+		if pad := (pieceLength - offset%pieceLength) % pieceLength; pad > 0 && i < len(files)-1 {
+			aligned = append(aligned, FileInfo{
+				Length: pad,
+				Path:   []string{padPathDir, strconv.FormatInt(pad, 10)},
+				Attr:   string(AttrPadding),
+			})
+			offset += pad
+		}
+	}
+	return aligned
+}
+
+// hashPieces fills in meta's piece hashes from storage.
+func hashPieces(meta *TorrentFileMeta, storage Storage, workers int) error {
+	return readPieces(meta, storage, workers, func(index int, piece []byte, err error) error {
+		if err != nil {
+			return fmt.Errorf("piece %d: %w", index, err)
+		}
+		meta.TorrentFileInfo.Info.Pieces[index] = sha1.Sum(piece)
+		return nil
+	})
+}
@@ -2,53 +2,21 @@ package torrent
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
-	"unicode"
 
-	bencode "github.com/jackpal/bencode-go" // Available if you need it!
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
 )
 
 // Example:
 // - 5:hello -> hello
 // - 10:hello12345 -> hello12345
+// - i52e -> 52
+// - l5:helloi52ee -> ["hello", 52]
+//
+// Input must be canonical bencode; errors are *bencode.SyntaxError values
+// carrying the offending byte offset.
 func DecodeBencode(bencodedString string) (interface{}, error) {
-	if unicode.IsDigit(rune(bencodedString[0])) {
-		var firstColonIndex int
-
-		for i := 0; i < len(bencodedString); i++ {
-			if bencodedString[i] == ':' {
-				firstColonIndex = i
-				break
-			}
-		}
-
-		lengthStr := bencodedString[:firstColonIndex]
-
-		length, err := strconv.Atoi(lengthStr)
-		if err != nil {
-			return "", err
-		}
-
-		return bencodedString[firstColonIndex+1 : firstColonIndex+1+length], nil
-	} else if number, intErr := decodeBenEncdoedNumber(bencodedString); intErr == nil {
-		return number, intErr
-	} else if data, err := bencode.Decode(strings.NewReader(bencodedString)); err == nil {
-		return data, err
-		// tr
-	} else {
-		return "", fmt.Errorf("only strings are supported at the moment")
+	if len(bencodedString) == 0 {
+		return nil, fmt.Errorf("empty bencoded value")
 	}
-}
-
-func decodeBenEncdoedNumber(bencodedString string) (int, error) {
-	strSize := len(bencodedString)
-	if strSize < 3 {
-		return -1, fmt.Errorf("not long enough for ben encoded int")
-	}
-	if bencodedString[0] != 'i' || bencodedString[strSize-1] != 'e' {
-		return -1, fmt.Errorf("doesn't have i and e surrounding the string")
-	}
-	return strconv.Atoi(bencodedString[1 : strSize-1])
-
+	return bencode.Decode([]byte(bencodedString))
 }
@@ -0,0 +1,166 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+type DiffKind string
+
+const (
+	DiffAdded   DiffKind = "added"
+	DiffRemoved DiffKind = "removed"
+	DiffChanged DiffKind = "changed"
+)
+
+// Difference is a single change between two bencoded documents. Old is unset
+// for additions and New for removals.
+type Difference struct {
+	Kind DiffKind
+	Path string
+	Old  interface{}
+	New  interface{}
+}
+
+type DiffResult struct {
+	Differences []Difference
+	// HasInfo is set when both documents carry an info dictionary, i.e. when
+	// they look like torrents and the info hash comparison is meaningful.
+	HasInfo     bool
+	InfoChanged bool
+	OldInfoHash []byte
+	NewInfoHash []byte
+}
+
+// DiffBencode compares two bencoded documents structurally.
+func DiffBencode(oldData, newData []byte) (*DiffResult, error) {
+	oldValue, err := bencode.Decode(oldData)
+	if err != nil {
+		return nil, fmt.Errorf("old: %w", err)
+	}
+	newValue, err := bencode.Decode(newData)
+	if err != nil {
+		return nil, fmt.Errorf("new: %w", err)
+	}
+
+	result := &DiffResult{Differences: DiffValues(oldValue, newValue)}
+	oldInfo, newInfo := rawInfo(oldData), rawInfo(newData)
+	if len(oldInfo) > 0 && len(newInfo) > 0 {
+		oldHash, newHash := sha1.Sum(oldInfo), sha1.Sum(newInfo)
+		result.HasInfo = true
+		result.InfoChanged = !bytes.Equal(oldInfo, newInfo)
+		result.OldInfoHash = oldHash[:]
+		result.NewInfoHash = newHash[:]
+	}
+	return result, nil
+}
+
+func rawInfo(data []byte) bencode.RawMessage {
+	var torrentFile struct {
+		Info bencode.RawMessage `bencode:"info"`
+	}
+	if err := bencode.Unmarshal(data, &torrentFile); err != nil {
+		return nil
+	}
+	return torrentFile.Info
+}
+
+// DiffValues compares two values produced by DecodeBencode. Dictionaries are
+// compared key by key and lists element by element.
+func DiffValues(oldValue, newValue interface{}) []Difference {
+	var differences []Difference
+	diffValues(&differences, "", oldValue, newValue)
+	return differences
+}
+
+func diffValues(differences *[]Difference, path string, oldValue, newValue interface{}) {
+	switch o := oldValue.(type) {
+	case map[string]interface{}:
+		n, ok := newValue.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(o)+len(n))
+		for key := range o {
+			keys = append(keys, key)
+		}
+		for key := range n {
+			if _, ok := o[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			oldElem, inOld := o[key]
+			newElem, inNew := n[key]
+			switch {
+			case !inNew:
+				*differences = append(*differences, Difference{Kind: DiffRemoved, Path: pathKey(path, key), Old: oldElem})
+			case !inOld:
+				*differences = append(*differences, Difference{Kind: DiffAdded, Path: pathKey(path, key), New: newElem})
+			default:
+				diffValues(differences, pathKey(path, key), oldElem, newElem)
+			}
+		}
+		return
+	case []interface{}:
+		n, ok := newValue.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(o) || i < len(n); i++ {
+			elemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(n):
+				*differences = append(*differences, Difference{Kind: DiffRemoved, Path: elemPath, Old: o[i]})
+			case i >= len(o):
+				*differences = append(*differences, Difference{Kind: DiffAdded, Path: elemPath, New: n[i]})
+			default:
+				diffValues(differences, elemPath, o[i], n[i])
+			}
+		}
+		return
+	default:
+		if oldValue == newValue {
+			return
+		}
+	}
+	*differences = append(*differences, Difference{Kind: DiffChanged, Path: path, Old: oldValue, New: newValue})
+}
+
+// String renders the result one change per line, with binary values as hex.
+func (result *DiffResult) String() string {
+	var lines []string
+	for _, difference := range result.Differences {
+		path := pathOrRoot(difference.Path)
+		switch difference.Kind {
+		case DiffAdded:
+			lines = append(lines, fmt.Sprintf("+ %s: %s", path, diffValueString(difference.New)))
+		case DiffRemoved:
+			lines = append(lines, fmt.Sprintf("- %s: %s", path, diffValueString(difference.Old)))
+		case DiffChanged:
+			lines = append(lines, fmt.Sprintf("~ %s: %s -> %s", path, diffValueString(difference.Old), diffValueString(difference.New)))
+		}
+	}
+	if result.HasInfo {
+		if result.InfoChanged {
+			lines = append(lines, fmt.Sprintf("info dictionaries differ: info hash %x -> %x", result.OldInfoHash, result.NewInfoHash))
+		} else {
+			lines = append(lines, fmt.Sprintf("info dictionaries identical: info hash %x", result.OldInfoHash))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func diffValueString(value interface{}) string {
+	output, err := FormatValue(value, FormatHex)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return output
+}
@@ -0,0 +1,100 @@
+package torrent
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+// EditOptions describes changes to an existing torrent. Only the fields
+// outside the info dictionary are touched unless Source or Private is set.
+type EditOptions struct {
+	// Set replaces top-level keys with the bencoding of their values;
+	// Delete removes top-level keys. Neither may name "info".
+	Set    map[string]interface{}
+	Delete []string
+	// Source and Private, when not nil, rewrite the info dictionary, which
+	// changes the info hash. Other info keys are copied verbatim.
+	Source  *string
+	Private *bool
+}
+
+type EditResult struct {
+	Data        []byte
+	OldInfoHash []byte
+	NewInfoHash []byte
+}
+
+func (result *EditResult) InfoChanged() bool {
+	return !bytes.Equal(result.OldInfoHash, result.NewInfoHash)
+}
+
+// EditTorrent applies options to bencoded metainfo. Keys it doesn't know are
+// preserved and the info dictionary is copied byte for byte unless options
+// change it.
+func EditTorrent(data []byte, options EditOptions) (*EditResult, error) {
+	old, err := ParseTorrent(data)
+	if err != nil {
+		return nil, err
+	}
+	var dict map[string]bencode.RawMessage
+	if err := bencode.Unmarshal(data, &dict); err != nil {
+		return nil, err
+	}
+	for _, key := range options.Delete {
+		if key == "info" {
+			return nil, fmt.Errorf("refusing to delete the info dictionary")
+		}
+		delete(dict, key)
+	}
+	for key, value := range options.Set {
+		if key == "info" {
+			return nil, fmt.Errorf("refusing to replace the info dictionary")
+		}
+		raw, err := bencode.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		dict[key] = raw
+	}
+	if options.Source != nil || options.Private != nil {
+		if dict["info"], err = editInfo(dict["info"], options); err != nil {
+			return nil, err
+		}
+	}
+
+	edited, err := bencode.Marshal(dict)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ParseTorrent(edited)
+	if err != nil {
+		return nil, fmt.Errorf("edited torrent is invalid: %w", err)
+	}
+	return &EditResult{Data: edited, OldInfoHash: old.InfoHashBytes, NewInfoHash: meta.InfoHashBytes}, nil
+}
+
+// editInfo sets or clears `source` and `private`. An empty source and a
+// false private flag remove the keys, as their absence means the same.
+func editInfo(rawInfo bencode.RawMessage, options EditOptions) (bencode.RawMessage, error) {
+	var info map[string]bencode.RawMessage
+	if err := bencode.Unmarshal(rawInfo, &info); err != nil {
+		return nil, err
+	}
+	if options.Source != nil {
+		if *options.Source == "" {
+			delete(info, "source")
+		} else {
+			info["source"] = bencode.MarshalString([]byte(*options.Source))
+		}
+	}
+	if options.Private != nil {
+		if *options.Private {
+			info["private"] = bencode.RawMessage("i1e")
+		} else {
+			delete(info, "private")
+		}
+	}
+	return bencode.Marshal(info)
+}
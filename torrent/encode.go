@@ -0,0 +1,105 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+// Marshal returns the canonical bencoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	return bencode.Marshal(v)
+}
+
+// Encode writes the canonical bencoding of v to w.
+func Encode(w io.Writer, v interface{}) error {
+	return bencode.Encode(w, v)
+}
+
+// EncodeJSON turns JSON as printed by the decode command back into bencode.
+// Numbers must be integers; booleans and null have no bencode form. Objects of
+// the form {"$hex": "..."} or {"$base64": "..."} become raw byte strings, and
+// keys of the form "$hex:..." or "$base64:..." raw byte keys; "$$key" is the
+// escaped form of a key that really starts with "$".
+func EncodeJSON(jsonData []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("unable to parse json: %w", err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unable to parse json: trailing data after value")
+	}
+	converted, err := fromJSON(value, "")
+	if err != nil {
+		return nil, err
+	}
+	return bencode.Marshal(converted)
+}
+
+func fromJSON(value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		n, err := strconv.ParseInt(v.String(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s is not a 64-bit integer", pathOrRoot(path), v)
+		}
+		return n, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			converted, err := fromJSON(elem, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = converted
+		}
+		return list, nil
+	case map[string]interface{}:
+		if decoded, ok, err := unmarkBinary(v); ok || err != nil {
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", pathOrRoot(path), err)
+			}
+			return decoded, nil
+		}
+		dict := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			converted, err := fromJSON(elem, joinPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			rawKey, err := unmarkKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: key %q: %w", pathOrRoot(path), key, err)
+			}
+			if _, ok := dict[rawKey]; ok {
+				return nil, fmt.Errorf("%s: duplicate key %q", pathOrRoot(path), key)
+			}
+			dict[rawKey] = converted
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("%s: %v has no bencode representation", pathOrRoot(path), value)
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
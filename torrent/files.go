@@ -0,0 +1,142 @@
+package torrent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// FileInfo is one entry of a multi-file torrent's `files` list.
+type FileInfo struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+	// Attr holds BEP 47 attribute flags, see the Attr constants.
+	Attr        string   `bencode:"attr,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+}
+
+// BEP 47 file attributes.
+const (
+	AttrPadding    = 'p'
+	AttrExecutable = 'x'
+	AttrHidden     = 'h'
+	AttrSymlink    = 'l'
+)
+
+// padPathDir is the directory padding files conventionally live under.
+const padPathDir = ".pad"
+
+// FileEntry places a file within the concatenated piece data. Path starts
+// with the torrent name for multi-file torrents.
+type FileEntry struct {
+	Path   []string
+	Length int64
+	Offset int64
+	// PiecesRoot is the v2 merkle root, set for files of pure v2 torrents.
+	PiecesRoot []byte
+	Attr       string
+	// SymlinkPath is the link target relative to the torrent root.
+	SymlinkPath []string
+}
+
+func (entry FileEntry) String() string {
+	return path.Join(entry.Path...)
+}
+
+// IsPadding files only align the next file to a piece boundary. They count
+// in piece math but are never written to disk.
+func (entry FileEntry) IsPadding() bool {
+	return strings.ContainsRune(entry.Attr, AttrPadding)
+}
+
+func (entry FileEntry) IsExecutable() bool {
+	return strings.ContainsRune(entry.Attr, AttrExecutable)
+}
+
+func (entry FileEntry) IsHidden() bool {
+	return strings.ContainsRune(entry.Attr, AttrHidden)
+}
+
+func (entry FileEntry) IsSymlink() bool {
+	return strings.ContainsRune(entry.Attr, AttrSymlink)
+}
+
+func (meta *TorrentFileMeta) IsMultiFile() bool {
+	if !meta.IsV1() {
+		return !meta.isV2SingleFile()
+	}
+	return len(meta.TorrentFileInfo.Info.Files) > 0
+}
+
+// Files lays out the torrent's files in piece order. Hybrid torrents use the
+// v1 layout, pure v2 torrents the file tree.
+func (meta *TorrentFileMeta) Files() []FileEntry {
+	info := meta.TorrentFileInfo.Info
+	if !meta.IsV1() {
+		return meta.filesV2()
+	}
+	if !meta.IsMultiFile() {
+		return []FileEntry{{Path: []string{info.Name}, Length: info.Length}}
+	}
+	entries := make([]FileEntry, len(info.Files))
+	var offset int64
+	for i, file := range info.Files {
+		entries[i] = FileEntry{
+			Path:        append([]string{info.Name}, file.Path...),
+			Length:      file.Length,
+			Offset:      offset,
+			Attr:        file.Attr,
+			SymlinkPath: file.SymlinkPath,
+		}
+		offset += file.Length
+	}
+	return entries
+}
+
+// checkPaths rejects file paths that could escape the download directory.
+func (meta *TorrentFileMeta) checkPaths() error {
+	info := meta.TorrentFileInfo.Info
+	if err := checkPathComponent(info.Name); err != nil {
+		return fmt.Errorf("name: %w", err)
+	}
+	for i, file := range info.Files {
+		if len(file.Path) == 0 {
+			return fmt.Errorf("file %d: empty path", i)
+		}
+		if err := checkPathComponents(file.Path, file.SymlinkPath); err != nil {
+			return fmt.Errorf("file %d: %w", i, err)
+		}
+	}
+	for i, file := range meta.filesV2() {
+		if err := checkPathComponents(file.Path, file.SymlinkPath); err != nil {
+			return fmt.Errorf("file tree entry %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// checkPathComponents checks a file path and its symlink target, if any; the
+// target is resolved against the torrent root and must not leave it either.
+func checkPathComponents(filePath, symlinkPath []string) error {
+	for _, component := range filePath {
+		if err := checkPathComponent(component); err != nil {
+			return err
+		}
+	}
+	for _, component := range symlinkPath {
+		if err := checkPathComponent(component); err != nil {
+			return fmt.Errorf("symlink path: %w", err)
+		}
+	}
+	return nil
+}
+
+func checkPathComponent(component string) error {
+	switch {
+	case component == "" || component == "." || component == "..":
+		return fmt.Errorf("invalid path component %q", component)
+	case strings.ContainsAny(component, "/\\\x00"):
+		return fmt.Errorf("path component %q contains a separator or NUL", component)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package torrent
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// OutputFormat selects how decoded bencode values are rendered.
+type OutputFormat string
+
+const (
+	// FormatJSON is plain encoding/json output. Binary strings are mangled.
+	FormatJSON OutputFormat = "json"
+	// FormatHex is JSON with non-UTF-8 strings written as {"$hex": "..."}.
+	FormatHex OutputFormat = "hex"
+	// FormatBase64 is JSON with non-UTF-8 strings written as {"$base64": "..."}.
+	FormatBase64 OutputFormat = "base64"
+	// FormatTree is an indented, human-oriented view.
+	FormatTree OutputFormat = "tree"
+)
+
+// Type markers used by FormatHex and FormatBase64. EncodeJSON understands them.
+// Dictionary keys can't be objects, so non-UTF-8 keys are written as
+// "$hex:..." or "$base64:...", and real keys starting with "$" get an extra
+// "$" so neither form is ambiguous.
+const (
+	hexMarker    = "$hex"
+	base64Marker = "$base64"
+	keyEscape    = "$"
+)
+
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	switch format := OutputFormat(name); format {
+	case FormatJSON, FormatHex, FormatBase64, FormatTree:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown output format %q (want json, hex, base64 or tree)", name)
+}
+
+// FormatValue renders a value produced by DecodeBencode.
+func FormatValue(value interface{}, format OutputFormat) (string, error) {
+	switch format {
+	case FormatJSON:
+		output, err := json.Marshal(value)
+		return string(output), err
+	case FormatHex, FormatBase64:
+		output, err := json.Marshal(markBinary(value, format))
+		return string(output), err
+	case FormatTree:
+		var sb strings.Builder
+		writeTree(&sb, value, 0)
+		return strings.TrimSuffix(sb.String(), "\n"), nil
+	}
+	return "", fmt.Errorf("unknown output format %q", format)
+}
+
+func markBinary(value interface{}, format OutputFormat) interface{} {
+	switch v := value.(type) {
+	case string:
+		if utf8.ValidString(v) || format == FormatJSON {
+			return v
+		}
+		if format == FormatBase64 {
+			return map[string]string{base64Marker: base64.StdEncoding.EncodeToString([]byte(v))}
+		}
+		return map[string]string{hexMarker: hex.EncodeToString([]byte(v))}
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			list[i] = markBinary(elem, format)
+		}
+		return list
+	case map[string]interface{}:
+		dict := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			dict[markKey(key, format)] = markBinary(elem, format)
+		}
+		return dict
+	}
+	return value
+}
+
+func markKey(key string, format OutputFormat) string {
+	switch {
+	case format == FormatJSON:
+		return key
+	case !utf8.ValidString(key) && format == FormatBase64:
+		return base64Marker + ":" + base64.StdEncoding.EncodeToString([]byte(key))
+	case !utf8.ValidString(key):
+		return hexMarker + ":" + hex.EncodeToString([]byte(key))
+	case strings.HasPrefix(key, keyEscape):
+		return keyEscape + key
+	}
+	return key
+}
+
+// unmarkKey reverses markKey.
+func unmarkKey(key string) (string, error) {
+	switch {
+	case strings.HasPrefix(key, keyEscape+keyEscape):
+		return key[len(keyEscape):], nil
+	case strings.HasPrefix(key, hexMarker+":"):
+		decoded, err := hex.DecodeString(key[len(hexMarker)+1:])
+		return string(decoded), err
+	case strings.HasPrefix(key, base64Marker+":"):
+		decoded, err := base64.StdEncoding.DecodeString(key[len(base64Marker)+1:])
+		return string(decoded), err
+	}
+	return key, nil
+}
+
+// unmarkBinary recognises a type-marker object from FormatHex/FormatBase64
+// output and returns the byte string it stands for.
+func unmarkBinary(dict map[string]interface{}) (string, bool, error) {
+	if len(dict) != 1 {
+		return "", false, nil
+	}
+	for key, value := range dict {
+		encoded, ok := value.(string)
+		if !ok {
+			return "", false, nil
+		}
+		switch key {
+		case hexMarker:
+			decoded, err := hex.DecodeString(encoded)
+			return string(decoded), true, err
+		case base64Marker:
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			return string(decoded), true, err
+		}
+	}
+	return "", false, nil
+}
+
+func treeString(s string) string {
+	if utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprintf("<%d bytes> %s", len(s), hex.EncodeToString([]byte(s)))
+}
+
+func writeTree(sb *strings.Builder, value interface{}, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch v := value.(type) {
+	case []interface{}:
+		fmt.Fprintf(sb, "list (%d items)\n", len(v))
+		for i, elem := range v {
+			fmt.Fprintf(sb, "%s  [%d]: ", indent, i)
+			writeTree(sb, elem, depth+1)
+		}
+	case map[string]interface{}:
+		fmt.Fprintf(sb, "dict (%d keys)\n", len(v))
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(sb, "%s  %s: ", indent, treeKey(key))
+			writeTree(sb, v[key], depth+1)
+		}
+	case string:
+		sb.WriteString(treeString(v) + "\n")
+	default:
+		fmt.Fprintf(sb, "%v\n", v)
+	}
+}
+
+func treeKey(key string) string {
+	if utf8.ValidString(key) && !strings.ContainsAny(key, ":\n") {
+		return key
+	}
+	return treeString(key)
+}
@@ -0,0 +1,392 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BEP 52 hash exchange messages.
+const (
+	MessageHashRequest = 21
+	MessageHashes      = 22
+	MessageHashReject  = 23
+)
+
+// maxHashRequestLength is the largest span of hashes we ask for or serve,
+// the limit BEP 52 recommends.
+const maxHashRequestLength = 512
+
+// v2ReservedByte/Bit mark BEP 52 support in the handshake.
+const (
+	v2ReservedByte = 7
+	v2ReservedBit  = 0x10
+)
+
+// HashRequest is the payload shared by hash request, hashes and hash reject:
+// Length hashes of layer BaseLayer (0 being the 16 KiB block layer) starting
+// at Index, plus the uncle hashes for ProofLayers ancestor layers.
+type HashRequest struct {
+	PiecesRoot  [MerkleHashSize]byte
+	BaseLayer   uint32
+	Index       uint32
+	Length      uint32
+	ProofLayers uint32
+}
+
+const hashRequestSize = MerkleHashSize + 16
+
+func parseHashRequest(message []byte) (HashRequest, error) {
+	var request HashRequest
+	if len(message) < hashRequestSize {
+		return request, fmt.Errorf("hash message of %d bytes is too short", len(message))
+	}
+	err := binary.Read(bytes.NewReader(message), binary.BigEndian, &request)
+	return request, err
+}
+
+func (request HashRequest) encode() []byte {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.BigEndian, request)
+	return buffer.Bytes()
+}
+
+// uncles is the number of uncle hashes that accompany the requested span.
+// The span's own layers are implied by its hashes, so proof starts at the
+// layer holding the span's subtree root.
+func (request HashRequest) uncles() int {
+	if spanLayers := log2(int64(request.Length)); int(request.ProofLayers) > spanLayers {
+		return int(request.ProofLayers) - spanLayers
+	}
+	return 0
+}
+
+// log2 of a power of two.
+func log2(n int64) int {
+	layers := 0
+	for ; n > 1; n /= 2 {
+		layers++
+	}
+	return layers
+}
+
+// merkleLayers builds every layer of the tree over leaves, padded to width
+// with pad, from the leaves up to the root.
+func merkleLayers(leaves [][MerkleHashSize]byte, width int, pad [MerkleHashSize]byte) [][][MerkleHashSize]byte {
+	layer := make([][MerkleHashSize]byte, width)
+	copy(layer, leaves)
+	for i := len(leaves); i < width; i++ {
+		layer[i] = pad
+	}
+	layers := [][][MerkleHashSize]byte{layer}
+	for len(layer) > 1 {
+		parent := make([][MerkleHashSize]byte, len(layer)/2)
+		for i := range parent {
+			parent[i] = sha256.Sum256(append(layer[2*i][:], layer[2*i+1][:]...))
+		}
+		layers = append(layers, parent)
+		layer = parent
+	}
+	return layers
+}
+
+// pieceLayerIndex is the layer number of the piece layer.
+func (meta *TorrentFileMeta) pieceLayerIndex() int {
+	return log2(meta.PieceLength() / MerkleBlockSize)
+}
+
+// fileByRoot finds the v2 file with the given pieces root.
+func (meta *TorrentFileMeta) fileByRoot(root []byte) (FileEntry, bool) {
+	for _, file := range meta.Files() {
+		if file.Length > 0 && bytes.Equal(file.PiecesRoot, root) {
+			return file, true
+		}
+	}
+	return FileEntry{}, false
+}
+
+// hashLayers returns the merkle layers of file from layer base up to the
+// root, as far as a request for length hashes at index needs them, with the
+// index of each layer's first hash. Layers at or above the piece layer come
+// from `piece layers`. Below it only the pieces under the requested span
+// are read from storage (which may be nil), so serving a request never
+// costs more than maxHashRequestLength blocks or one piece.
+func (meta *TorrentFileMeta) hashLayers(file FileEntry, storage Storage, base, index, length int) ([][][MerkleHashSize]byte, []int, error) {
+	pieceLayer := meta.pieceLayerIndex()
+	pieceBlocks := int(meta.PieceLength() / MerkleBlockSize)
+	if file.Length <= meta.PieceLength() {
+		// the whole tree is a single piece
+		blocks := int((file.Length + MerkleBlockSize - 1) / MerkleBlockSize)
+		layers, err := meta.pieceSubtree(file, storage, 0, 1, nextPowerOfTwo(blocks))
+		if err != nil {
+			return nil, nil, err
+		}
+		if base >= len(layers) {
+			return nil, nil, fmt.Errorf("layer %d is above the root", base)
+		}
+		return layers[base:], make([]int, len(layers)-base), nil
+	}
+
+	layer := meta.PieceLayer(file)
+	if layer == nil {
+		return nil, nil, fmt.Errorf("no piece layer for %s", file)
+	}
+	upper := merkleLayers(layer, nextPowerOfTwo(len(layer)), zeroSubtreeRoot(pieceBlocks))
+	if base >= pieceLayer {
+		if base-pieceLayer >= len(upper) {
+			return nil, nil, fmt.Errorf("layer %d is above the root", base)
+		}
+		return upper[base-pieceLayer:], make([]int, len(upper)-(base-pieceLayer)), nil
+	}
+
+	// the span is aligned, so it covers a power of two of whole pieces or
+	// lies within one
+	first := index >> uint(pieceLayer-base)
+	count := length >> uint(pieceLayer-base)
+	if count == 0 {
+		count = 1
+	}
+	lower, err := meta.pieceSubtree(file, storage, first, count, count*pieceBlocks)
+	if err != nil {
+		return nil, nil, err
+	}
+	var layers [][][MerkleHashSize]byte
+	var offsets []int
+	for k := base; k < pieceLayer; k++ {
+		layers = append(layers, lower[k])
+		offsets = append(offsets, first<<uint(pieceLayer-k))
+	}
+	layers = append(layers, upper...)
+	offsets = append(offsets, make([]int, len(upper))...)
+	return layers, offsets, nil
+}
+
+// pieceSubtree reads count pieces of file from piece first on and returns
+// the layers of the tree over their blocks, padded to width leaves.
+func (meta *TorrentFileMeta) pieceSubtree(file FileEntry, storage Storage, first, count, width int) ([][][MerkleHashSize]byte, error) {
+	if storage == nil {
+		return nil, fmt.Errorf("no data to hash piece %d of %s", first, file)
+	}
+	start := int64(first) * meta.PieceLength()
+	size := int64(count) * meta.PieceLength()
+	if remaining := file.Length - start; remaining < size {
+		size = remaining
+	}
+	if size < 0 {
+		// past the end of the file, where the tree is all padding
+		size = 0
+	}
+	data := make([]byte, size)
+	if _, err := storage.ReadAt(data, file.Offset+start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return merkleLayers(blockHashes(data), width, [MerkleHashSize]byte{}), nil
+}
+
+// ServeHashRequest answers a hash request with the requested hashes followed
+// by their uncle hashes, ordered from the leaves towards the root.
+func (meta *TorrentFileMeta) ServeHashRequest(request HashRequest, storage Storage) ([]byte, error) {
+	file, ok := meta.fileByRoot(request.PiecesRoot[:])
+	if !ok {
+		return nil, fmt.Errorf("unknown pieces root %x", request.PiecesRoot)
+	}
+	length := request.Length
+	if length < 2 || length > maxHashRequestLength || length&(length-1) != 0 || request.Index%length != 0 {
+		return nil, fmt.Errorf("invalid hash request span %d+%d", request.Index, length)
+	}
+	layers, offsets, err := meta.hashLayers(file, storage, int(request.BaseLayer), int(request.Index), int(length))
+	if err != nil {
+		return nil, err
+	}
+	first := int(request.Index) - offsets[0]
+	if first < 0 || first+int(length) > len(layers[0]) {
+		return nil, fmt.Errorf("hash request span %d+%d exceeds layer of %d", request.Index, length, len(layers[0]))
+	}
+	var hashes []byte
+	for _, hash := range layers[0][first : first+int(length)] {
+		hashes = append(hashes, hash[:]...)
+	}
+	// the span's subtree root sits log2(length) layers above the base
+	spanLayers := log2(int64(length))
+	position := int(request.Index >> uint(spanLayers))
+	for layer := spanLayers; layer < spanLayers+request.uncles() && layer < len(layers)-1; layer++ {
+		sibling := position ^ 1 - offsets[layer]
+		if sibling < 0 || sibling >= len(layers[layer]) {
+			return nil, fmt.Errorf("uncle %d of layer %d is out of range", position^1, layer)
+		}
+		uncle := layers[layer][sibling]
+		hashes = append(hashes, uncle[:]...)
+		position /= 2
+	}
+	return hashes, nil
+}
+
+// HandleHashRequest answers a hash request message from a peer with hashes,
+// or hash reject when we can't serve it.
+func (client *Client) HandleHashRequest(peerAddress string, meta *TorrentFileMeta, storage Storage, message []byte) error {
+	request, err := parseHashRequest(message)
+	if err != nil {
+		return err
+	}
+	hashes, err := meta.ServeHashRequest(request, storage)
+	if err != nil {
+		return client.SendMessage(peerAddress, MessageHashReject, request.encode())
+	}
+	return client.SendMessage(peerAddress, MessageHashes, append(request.encode(), hashes...))
+}
+
+var errHashReject = errors.New("peer rejected hash request")
+
+// RequestHashes sends a hash request and returns the hashes and uncles of
+// the peer's answer, unverified.
+func (client *Client) RequestHashes(peerAddress string, request HashRequest) ([][MerkleHashSize]byte, [][MerkleHashSize]byte, error) {
+	if err := client.SendMessage(peerAddress, MessageHashRequest, request.encode()); err != nil {
+		return nil, nil, err
+	}
+	for {
+		messageType, message, err := client.RecieveMessage(peerAddress)
+		if err != nil {
+			return nil, nil, err
+		}
+		if messageType == MessageHashRequest && client.Meta != nil {
+			// serve what piece layers we have while we wait
+			if err := client.HandleHashRequest(peerAddress, client.Meta, nil, message); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if messageType != MessageHashes && messageType != MessageHashReject {
+			continue
+		}
+		reply, err := parseHashRequest(message)
+		if err != nil {
+			return nil, nil, err
+		}
+		if reply != request {
+			continue
+		}
+		if messageType == MessageHashReject {
+			return nil, nil, errHashReject
+		}
+		payload := message[hashRequestSize:]
+		want := (int(request.Length) + request.uncles()) * MerkleHashSize
+		if len(payload) > want || len(payload) < int(request.Length)*MerkleHashSize || len(payload)%MerkleHashSize != 0 {
+			return nil, nil, fmt.Errorf("hashes message has %d bytes, want %d", len(payload), want)
+		}
+		hashes := make([][MerkleHashSize]byte, len(payload)/MerkleHashSize)
+		for i := range hashes {
+			copy(hashes[i][:], payload[i*MerkleHashSize:])
+		}
+		return hashes[:request.Length], hashes[request.Length:], nil
+	}
+}
+
+// verifyProof hashes the span up to its subtree root and then through the
+// uncles, and checks the result against root.
+func verifyProof(request HashRequest, hashes, uncles [][MerkleHashSize]byte, root [MerkleHashSize]byte) error {
+	node := merkleRoot(hashes, len(hashes), [MerkleHashSize]byte{})
+	position := request.Index / request.Length
+	for _, uncle := range uncles {
+		if position%2 == 0 {
+			node = sha256.Sum256(append(node[:], uncle[:]...))
+		} else {
+			node = sha256.Sum256(append(uncle[:], node[:]...))
+		}
+		position /= 2
+	}
+	if node != root {
+		return fmt.Errorf("hashes %d+%d of layer %d fail merkle proof", request.Index, request.Length, request.BaseLayer)
+	}
+	return nil
+}
+
+// FetchPieceLayer requests the piece layer of file with proofs up to its
+// pieces root and stores it in meta, for v2 torrents whose metadata came
+// from a peer rather than a .torrent file.
+func (client *Client) FetchPieceLayer(peerAddress string, meta *TorrentFileMeta, file FileEntry) error {
+	if file.Length <= meta.PieceLength() || meta.PieceLayer(file) != nil {
+		return nil
+	}
+	var root [MerkleHashSize]byte
+	copy(root[:], file.PiecesRoot)
+	pieces := meta.V2PieceCount(file)
+	width := nextPowerOfTwo(pieces)
+	span := width
+	if span > maxHashRequestLength {
+		span = maxHashRequestLength
+	}
+	var layer []byte
+	for index := 0; index < pieces; index += span {
+		request := HashRequest{
+			PiecesRoot:  root,
+			BaseLayer:   uint32(meta.pieceLayerIndex()),
+			Index:       uint32(index),
+			Length:      uint32(span),
+			ProofLayers: uint32(log2(int64(width))),
+		}
+		hashes, uncles, err := client.RequestHashes(peerAddress, request)
+		if err != nil {
+			return err
+		}
+		if err := verifyProof(request, hashes, uncles, root); err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			layer = append(layer, hash[:]...)
+		}
+	}
+	if meta.TorrentFileInfo.PieceLayers == nil {
+		meta.TorrentFileInfo.PieceLayers = make(map[string]string)
+	}
+	meta.TorrentFileInfo.PieceLayers[string(file.PiecesRoot)] = string(layer[:pieces*MerkleHashSize])
+	return nil
+}
+
+// blockLeaves returns the verified 16 KiB block hashes of piece index of
+// file, so each block can be checked as soon as it arrives.
+func (client *Client) blockLeaves(peerAddress string, meta *TorrentFileMeta, file FileEntry, index int) ([][MerkleHashSize]byte, error) {
+	var target [MerkleHashSize]byte
+	width := int(meta.PieceLength() / MerkleBlockSize)
+	if file.Length <= meta.PieceLength() {
+		copy(target[:], file.PiecesRoot)
+		width = nextPowerOfTwo(int((file.Length + MerkleBlockSize - 1) / MerkleBlockSize))
+	} else {
+		if err := client.FetchPieceLayer(peerAddress, meta, file); err != nil {
+			return nil, err
+		}
+		var ok bool
+		if target, ok = meta.pieceLayerHash(file, index); !ok {
+			return nil, fmt.Errorf("missing piece layer for %s", file)
+		}
+	}
+	if width == 1 {
+		return [][MerkleHashSize]byte{target}, nil
+	}
+	// peers serve at most maxHashRequestLength hashes at a time, so large
+	// pieces take several spans, each proven up to the piece's subtree root
+	span := width
+	if span > maxHashRequestLength {
+		span = maxHashRequestLength
+	}
+	var leaves [][MerkleHashSize]byte
+	for offset := 0; offset < width; offset += span {
+		request := HashRequest{
+			Index:       uint32(index*width + offset),
+			Length:      uint32(span),
+			ProofLayers: uint32(log2(int64(width))),
+		}
+		copy(request.PiecesRoot[:], file.PiecesRoot)
+		hashes, uncles, err := client.RequestHashes(peerAddress, request)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyProof(request, hashes, uncles, target); err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, hashes...)
+	}
+	return leaves, nil
+}
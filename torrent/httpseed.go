@@ -0,0 +1,153 @@
+package torrent
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHTTPSeedRetries = 5
+	defaultHTTPSeedMaxWait = time.Minute
+	// defaultHTTPSeedBackoff applies when a busy reply doesn't say how long
+	// to wait.
+	defaultHTTPSeedBackoff = 30 * time.Second
+)
+
+// HTTPSeed is a BEP 17 (Hoffman-style) HTTP seed, a server answering
+// `?info_hash=&piece=` with the piece data, or 503 and a number of seconds to
+// come back after when it is busy.
+type HTTPSeed struct {
+	URL    string
+	Client *http.Client
+	// MaxRetries is how many busy replies one piece waits out.
+	MaxRetries int
+	// MaxWait is the longest back-off waited out in place. A longer one
+	// fails the piece with a *RetryAfterError so other peers can take it.
+	MaxWait time.Duration
+	retryAt time.Time
+}
+
+// RetryAfterError is returned by HTTPSeed.FetchPiece while the seed has
+// asked us to back off.
+type RetryAfterError struct {
+	URL  string
+	Wait time.Duration
+}
+
+func (err *RetryAfterError) Error() string {
+	return fmt.Sprintf("http seed %s is busy, retry after %s", err.URL, err.Wait.Round(time.Second))
+}
+
+// NewHTTPSeed returns an HTTP seed for rawURL. httpClient may be nil.
+func NewHTTPSeed(rawURL string, httpClient *http.Client) *HTTPSeed {
+	if httpClient == nil {
+		httpClient = webSeedHTTPClient
+	}
+	return &HTTPSeed{
+		URL:        rawURL,
+		Client:     httpClient,
+		MaxRetries: defaultHTTPSeedRetries,
+		MaxWait:    defaultHTTPSeedMaxWait,
+	}
+}
+
+// HTTPSeeds returns the torrent's BEP 17 seeds. Non-HTTP URLs are skipped.
+func (meta *TorrentFileMeta) HTTPSeeds(httpClient *http.Client) []PiecePeer {
+	var seeds []PiecePeer
+	for _, rawURL := range meta.TorrentFileInfo.HTTPSeeds {
+		if parsed, err := url.Parse(rawURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			fmt.Printf("Skipping http seed %q\n", rawURL)
+			continue
+		}
+		seeds = append(seeds, NewHTTPSeed(rawURL, httpClient))
+	}
+	return seeds
+}
+
+func (seed *HTTPSeed) String() string {
+	return seed.URL
+}
+
+// FetchPiece requests piece index, waiting out busy replies, and checks it
+// against the piece hashes.
+func (seed *HTTPSeed) FetchPiece(meta *TorrentFileMeta, index int) ([]byte, error) {
+	layout, err := meta.pieceLayout()
+	if err != nil {
+		return nil, err
+	}
+	span, err := layout.span(index)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		if wait := time.Until(seed.retryAt); wait > 0 {
+			if wait > seed.MaxWait || attempt > seed.MaxRetries {
+				return nil, &RetryAfterError{URL: seed.URL, Wait: wait}
+			}
+			fmt.Printf("http seed %s is busy, waiting %s\n", seed.URL, wait.Round(time.Second))
+			time.Sleep(wait)
+		}
+		piece, wait, err := seed.request(meta, index, span.length)
+		if err != nil {
+			return nil, err
+		}
+		if wait > 0 {
+			seed.retryAt = time.Now().Add(wait)
+			continue
+		}
+		if err := meta.verifySpan(layout.files, span, index, piece); err != nil {
+			return nil, fmt.Errorf("http seed %s: piece %d: %w", seed.URL, index, err)
+		}
+		return piece, nil
+	}
+}
+
+// request fetches a whole piece. A busy reply returns the back-off instead.
+func (seed *HTTPSeed) request(meta *TorrentFileMeta, index int, length int64) ([]byte, time.Duration, error) {
+	requestURL, err := url.Parse(seed.URL)
+	if err != nil {
+		return nil, 0, err
+	}
+	query := requestURL.Query()
+	query.Set("info_hash", string(meta.InfoHashBytes))
+	query.Set("piece", strconv.Itoa(index))
+	requestURL.RawQuery = query.Encode()
+
+	res, err := seed.Client.Get(requestURL.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusServiceUnavailable:
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 64))
+		return nil, retryAfter(string(body), res.Header.Get("Retry-After")), nil
+	default:
+		return nil, 0, fmt.Errorf("http seed %s: unexpected status %s", seed.URL, res.Status)
+	}
+	piece, err := io.ReadAll(io.LimitReader(res.Body, length+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(piece)) != length {
+		return nil, 0, fmt.Errorf("http seed %s: piece %d has %d bytes, want %d", seed.URL, index, len(piece), length)
+	}
+	return piece, 0, nil
+}
+
+// retryAfter reads the back-off in seconds from a busy reply's body, as BEP
+// 17 specifies, falling back to the Retry-After header.
+func retryAfter(body, header string) time.Duration {
+	for _, value := range []string{body, header} {
+		if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultHTTPSeedBackoff
+}
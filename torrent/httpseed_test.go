@@ -0,0 +1,148 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// memStorage is storage over a byte slice.
+type memStorage struct {
+	data []byte
+}
+
+func (storage *memStorage) ReadAt(p []byte, off int64) (int, error) {
+	return copy(p, storage.data[off:]), nil
+}
+
+func (storage *memStorage) WriteAt(p []byte, off int64) (int, error) {
+	return copy(storage.data[off:], p), nil
+}
+
+func (storage *memStorage) Close() error {
+	return nil
+}
+
+// httpSeedServer serves content, or the torrent's own content when nil, as
+// the pieces of a single-file torrent.
+func httpSeedServer(t *testing.T, content []byte) (*HTTPSeedServer, *TorrentFileMeta, []byte, *httptest.Server) {
+	t.Helper()
+	meta, data := webSeedTorrent(t, t.TempDir(), "file.bin", []webSeedFile{{path: []string{"file.bin"}, length: 40000}})
+	if content == nil {
+		content = data
+	}
+	seedServer := NewHTTPSeedServer()
+	if err := seedServer.Add(meta, &memStorage{data: content}); err != nil {
+		t.Fatal(err)
+	}
+	return seedServer, meta, content, httptest.NewServer(seedServer)
+}
+
+func TestHTTPSeedFetchPiece(t *testing.T) {
+	seedServer, meta, _, server := httpSeedServer(t, nil)
+	defer server.Close()
+	seedServer.MaxActive = 1
+
+	seed := NewHTTPSeed(server.URL, server.Client())
+	for index := 0; index < meta.NumPieces(); index++ {
+		if _, err := seed.FetchPiece(meta, index); err != nil {
+			t.Fatalf("piece %d: %v", index, err)
+		}
+	}
+}
+
+func TestHTTPSeedBusy(t *testing.T) {
+	seedServer, meta, _, server := httpSeedServer(t, nil)
+	defer server.Close()
+	seedServer.MaxActive = 1
+	seedServer.RetryAfter = 1
+	// take the only slot, and give it back before the back-off is over
+	seedServer.acquire()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		seedServer.release()
+	}()
+
+	seed := NewHTTPSeed(server.URL, server.Client())
+	start := time.Now()
+	if _, err := seed.FetchPiece(meta, 0); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("fetched after %s, want a back-off of at least 1s", waited)
+	}
+}
+
+func TestHTTPSeedRetryAfterError(t *testing.T) {
+	seedServer, meta, _, server := httpSeedServer(t, nil)
+	defer server.Close()
+	seedServer.MaxActive = 1
+	seedServer.RetryAfter = 120
+	seedServer.acquire()
+	defer seedServer.release()
+
+	seed := NewHTTPSeed(server.URL, server.Client())
+	seed.MaxWait = time.Minute
+	_, err := seed.FetchPiece(meta, 0)
+	var retryErr *RetryAfterError
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("got %v, want a RetryAfterError", err)
+	}
+	if retryErr.Wait <= time.Minute || retryErr.Wait > 120*time.Second {
+		t.Errorf("retry after %s, want about 120s", retryErr.Wait)
+	}
+}
+
+func TestHTTPSeedHashMismatch(t *testing.T) {
+	_, meta, _, server := httpSeedServer(t, make([]byte, 40000))
+	defer server.Close()
+
+	if _, err := NewHTTPSeed(server.URL, server.Client()).FetchPiece(meta, 0); err == nil {
+		t.Fatal("FetchPiece accepted a piece that fails its hash")
+	}
+}
+
+func TestParsePieceRanges(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    [][2]int64
+		wantErr bool
+	}{
+		{"", [][2]int64{{0, 99}}, false},
+		{"0-9", [][2]int64{{0, 9}}, false},
+		{"0-9,50-99", [][2]int64{{0, 9}, {50, 99}}, false},
+		{"0-100", nil, true},
+		{"9-0", nil, true},
+		{"-5", nil, true},
+		{"5", nil, true},
+		{"a-b", nil, true},
+	}
+	for _, test := range tests {
+		got, err := parsePieceRanges(test.value, 100)
+		if (err != nil) != test.wantErr || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parsePieceRanges(%q) = %v, %v; want %v, error %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestHTTPSeedServerRanges(t *testing.T) {
+	_, meta, content, server := httpSeedServer(t, nil)
+	defer server.Close()
+
+	res, err := server.Client().Get(server.URL + "?info_hash=" + url.QueryEscape(string(meta.InfoHashBytes)) + "&piece=1&ranges=0-9,100-109")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var body bytes.Buffer
+	body.ReadFrom(res.Body)
+	offset := meta.PieceOffset(1)
+	want := append(append([]byte(nil), content[offset:offset+10]...), content[offset+100:offset+110]...)
+	if !bytes.Equal(body.Bytes(), want) {
+		t.Errorf("ranges returned %d bytes that don't match the piece", body.Len())
+	}
+}
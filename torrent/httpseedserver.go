@@ -0,0 +1,120 @@
+package torrent
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HTTPSeedServer is a reference BEP 17 seed serving pieces from local
+// storage. Beyond MaxActive concurrent requests it answers 503 with
+// RetryAfter seconds, which makes it handy for exercising client back-off.
+type HTTPSeedServer struct {
+	MaxActive  int
+	RetryAfter int
+
+	mu       sync.Mutex
+	active   int
+	torrents map[string]httpSeedTorrent
+}
+
+type httpSeedTorrent struct {
+	meta    *TorrentFileMeta
+	storage Storage
+	spans   []pieceSpan
+}
+
+func NewHTTPSeedServer() *HTTPSeedServer {
+	return &HTTPSeedServer{RetryAfter: 5, torrents: make(map[string]httpSeedTorrent)}
+}
+
+// Add serves meta's pieces from storage.
+func (server *HTTPSeedServer) Add(meta *TorrentFileMeta, storage Storage) error {
+	spans, err := meta.pieceSpans()
+	if err != nil {
+		return err
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.torrents[string(meta.InfoHashBytes)] = httpSeedTorrent{meta: meta, storage: storage, spans: spans}
+	return nil
+}
+
+func (server *HTTPSeedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	server.mu.Lock()
+	seeded, ok := server.torrents[query.Get("info_hash")]
+	server.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown info_hash", http.StatusNotFound)
+		return
+	}
+	index, err := strconv.Atoi(query.Get("piece"))
+	if err != nil || index < 0 || index >= len(seeded.spans) {
+		http.Error(w, "invalid piece", http.StatusBadRequest)
+		return
+	}
+	span := seeded.spans[index]
+	ranges, err := parsePieceRanges(query.Get("ranges"), span.length)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !server.acquire() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%d", server.RetryAfter)
+		return
+	}
+	defer server.release()
+
+	piece := make([]byte, span.length)
+	if _, err := seeded.storage.ReadAt(piece, span.offset); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	for _, byteRange := range ranges {
+		w.Write(piece[byteRange[0] : byteRange[1]+1])
+	}
+}
+
+func (server *HTTPSeedServer) acquire() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.MaxActive > 0 && server.active >= server.MaxActive {
+		return false
+	}
+	server.active++
+	return true
+}
+
+func (server *HTTPSeedServer) release() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.active--
+}
+
+// parsePieceRanges parses `ranges=a-b,c-d`, inclusive byte ranges within a
+// piece of length bytes. No ranges means the whole piece.
+func parsePieceRanges(value string, length int64) ([][2]int64, error) {
+	if value == "" {
+		return [][2]int64{{0, length - 1}}, nil
+	}
+	var ranges [][2]int64
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		start, err1 := strconv.ParseInt(bounds[0], 10, 64)
+		end, err2 := strconv.ParseInt(bounds[1], 10, 64)
+		if err1 != nil || err2 != nil || start < 0 || end < start || end >= length {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		ranges = append(ranges, [2]int64{start, end})
+	}
+	return ranges, nil
+}
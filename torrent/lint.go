@@ -0,0 +1,244 @@
+package torrent
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+)
+
+// Finding is one problem found by Validate or LintTorrent. Code is a stable
+// identifier for the kind of problem, Field the metainfo path it concerns.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Field    string   `json:"field,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (finding Finding) String() string {
+	if finding.Field == "" {
+		return fmt.Sprintf("%s [%s] %s", finding.Severity, finding.Code, finding.Message)
+	}
+	return fmt.Sprintf("%s [%s] %s: %s", finding.Severity, finding.Code, finding.Field, finding.Message)
+}
+
+const (
+	minSensiblePieceLength = 16 * 1024
+	maxSensiblePieceLength = 64 * 1024 * 1024
+)
+
+// windowsReservedNames can't be used as file names on Windows, with or
+// without an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// LintTorrent checks bencoded metainfo. Unlike ParseTorrent it doesn't stop
+// at the first problem: anything that can still be decoded is validated.
+func LintTorrent(data []byte) []Finding {
+	var torrentFile TorrentFile
+	if err := bencode.Unmarshal(data, &torrentFile); err != nil {
+		return []Finding{{SeverityError, "bencode", "", err.Error()}}
+	}
+	if len(torrentFile.RawInfo) == 0 {
+		return []Finding{{SeverityError, "missing-info", "info", "no info dictionary"}}
+	}
+
+	// decode pieces separately so a bad length is a finding, not a failure
+	var findings []Finding
+	var infoDict map[string]bencode.RawMessage
+	if err := bencode.Unmarshal(torrentFile.RawInfo, &infoDict); err != nil {
+		return []Finding{{SeverityError, "bencode", "info", err.Error()}}
+	}
+	var pieces []byte
+	if raw, ok := infoDict["pieces"]; ok {
+		var err error
+		if pieces, err = bencode.UnmarshalString(raw); err != nil {
+			findings = append(findings, Finding{SeverityError, "pieces-type", "info.pieces", err.Error()})
+		}
+		delete(infoDict, "pieces")
+	}
+	if len(pieces)%PieceHashSize != 0 {
+		findings = append(findings, Finding{SeverityError, "pieces-length", "info.pieces",
+			fmt.Sprintf("length %d is not a multiple of %d", len(pieces), PieceHashSize)})
+	}
+	rest, err := bencode.Marshal(infoDict)
+	if err == nil {
+		err = bencode.Unmarshal(rest, &torrentFile.Info)
+	}
+	if err != nil {
+		return append(findings, Finding{SeverityError, "info-type", "info", err.Error()})
+	}
+	torrentFile.Info.Pieces = make(PieceHashes, len(pieces)/PieceHashSize)
+	for i := range torrentFile.Info.Pieces {
+		copy(torrentFile.Info.Pieces[i][:], pieces[i*PieceHashSize:])
+	}
+
+	meta := &TorrentFileMeta{TorrentFileInfo: torrentFile, InfoHashBytes: torrentInfoHash(&torrentFile)}
+	return append(findings, meta.Validate()...)
+}
+
+// Validate checks the metainfo for problems that are unsafe or that make the
+// torrent unusable, plus a few that are merely unusual.
+func (meta *TorrentFileMeta) Validate() []Finding {
+	var findings []Finding
+	add := func(severity Severity, code, field, format string, args ...interface{}) {
+		findings = append(findings, Finding{severity, code, field, fmt.Sprintf(format, args...)})
+	}
+	info := meta.TorrentFileInfo.Info
+
+	pieceLength := info.PieceLength
+	switch {
+	case pieceLength <= 0:
+		add(SeverityError, "piece-length", "info.piece length", "must be positive, got %d", pieceLength)
+	case pieceLength > math.MaxUint32:
+		add(SeverityError, "piece-length", "info.piece length", "%d does not fit the wire protocol", pieceLength)
+	case pieceLength&(pieceLength-1) != 0:
+		add(SeverityWarning, "piece-length", "info.piece length", "%d is not a power of two", pieceLength)
+	case pieceLength < minSensiblePieceLength:
+		add(SeverityWarning, "piece-length", "info.piece length", "%d is below %d", pieceLength, minSensiblePieceLength)
+	case pieceLength > maxSensiblePieceLength:
+		add(SeverityWarning, "piece-length", "info.piece length", "%d is above %d", pieceLength, maxSensiblePieceLength)
+	}
+
+	var total int64
+	lengthsOK := info.Length >= 0
+	if !lengthsOK {
+		add(SeverityError, "length", "info.length", "negative length %d", info.Length)
+	}
+	for i, file := range info.Files {
+		field := fmt.Sprintf("info.files[%d].length", i)
+		if file.Length < 0 {
+			add(SeverityError, "length", field, "negative length %d", file.Length)
+			lengthsOK = false
+		} else if file.Length > math.MaxInt64-total {
+			add(SeverityError, "length", field, "total length overflows")
+			lengthsOK = false
+		} else {
+			total += file.Length
+		}
+	}
+	if info.Length > 0 && len(info.Files) > 0 {
+		add(SeverityError, "layout", "info", "both length and files are set")
+	}
+
+	if meta.IsV1() && lengthsOK {
+		totalLength := meta.TotalLength()
+		if totalLength == 0 || meta.NumPieces() == 0 {
+			add(SeverityError, "zero-pieces", "info.pieces", "torrent has no pieces")
+		} else if pieceLength > 0 {
+			if want := (totalLength + pieceLength - 1) / pieceLength; int64(meta.NumPieces()) != want {
+				add(SeverityError, "piece-count", "info.pieces", "%d hashes for %d bytes, want ceil(%d/%d) = %d",
+					meta.NumPieces(), totalLength, totalLength, pieceLength, want)
+			}
+		}
+	}
+	if meta.IsV2() {
+		if err := meta.checkV2(); err != nil {
+			add(SeverityError, "v2", "info", "%s", err)
+		}
+	}
+
+	if info.Name == "" {
+		add(SeverityWarning, "name", "info.name", "empty name")
+	} else {
+		findings = append(findings, lintPathComponent("info.name", info.Name)...)
+	}
+	seen := make(map[string]string)
+	seenFold := make(map[string]string)
+	for i, file := range meta.Files() {
+		field := fmt.Sprintf("file %s", file)
+		if meta.IsV1() && meta.IsMultiFile() {
+			field = fmt.Sprintf("info.files[%d]", i)
+		}
+		if len(file.Path) == 0 || (meta.IsMultiFile() && len(file.Path) < 2) {
+			add(SeverityError, "path", field, "empty path")
+			continue
+		}
+		components := file.Path[1:]
+		if !meta.IsMultiFile() && !meta.IsV1() {
+			// a v2 single file is named by its file tree key, not info.name
+			components = file.Path
+		}
+		for _, component := range components {
+			findings = append(findings, lintPathComponent(field+".path", component)...)
+		}
+		for _, component := range file.SymlinkPath {
+			findings = append(findings, lintPathComponent(field+".symlink path", component)...)
+		}
+		if file.IsPadding() {
+			// padding files routinely share names
+			continue
+		}
+		key := strings.Join(file.Path, "/")
+		if previous, ok := seen[key]; ok {
+			add(SeverityError, "duplicate-path", field, "%s duplicates %s", key, previous)
+		} else if previous, ok := seenFold[strings.ToLower(key)]; ok {
+			add(SeverityWarning, "duplicate-path", field, "%s differs from %s only in case", key, previous)
+		}
+		seen[key] = field
+		seenFold[strings.ToLower(key)] = field
+	}
+
+	if len(meta.Trackers()) == 0 {
+		if meta.IsPrivate() {
+			add(SeverityWarning, "no-trackers", "announce", "private torrent has no trackers, its only peer source")
+		} else {
+			add(SeverityInfo, "no-trackers", "announce", "no trackers; peers must come from DHT or peer exchange")
+		}
+	}
+	return findings
+}
+
+// lintPathComponent reports unsafe and unportable path components.
+func lintPathComponent(field, component string) []Finding {
+	var findings []Finding
+	add := func(severity Severity, code, format string, args ...interface{}) {
+		findings = append(findings, Finding{severity, code, field, fmt.Sprintf(format, args...)})
+	}
+	absolute := len(component) >= 2 && component[1] == ':' && isASCIILetter(component[0])
+	switch {
+	case component == "":
+		add(SeverityError, "path", "empty path component")
+	case component == "." || component == "..":
+		add(SeverityError, "path-traversal", "component %q escapes the download directory", component)
+	case strings.ContainsRune(component, 0):
+		add(SeverityError, "path-nul", "component %q contains NUL", component)
+	case strings.ContainsAny(component, "/\\"):
+		add(SeverityError, "path-separator", "component %q contains a path separator", component)
+	case absolute:
+		add(SeverityError, "path-absolute", "component %q is a drive-absolute path", component)
+	}
+	base := strings.ToUpper(component)
+	if dot := strings.IndexByte(base, '.'); dot >= 0 {
+		base = base[:dot]
+	}
+	if windowsReservedNames[base] {
+		add(SeverityWarning, "path-reserved", "%q is a reserved name on Windows", component)
+	}
+	if strings.HasSuffix(component, ".") && component != "." && component != ".." || strings.HasSuffix(component, " ") {
+		add(SeverityWarning, "path-trailing", "%q ends in a dot or space, which Windows strips", component)
+	}
+	if strings.ContainsAny(component, "<>:\"|?*") && !absolute {
+		add(SeverityWarning, "path-chars", "%q contains characters not allowed on Windows", component)
+	}
+	return findings
+}
+
+func isASCIILetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
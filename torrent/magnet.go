@@ -0,0 +1,235 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Magnet is a parsed magnet URI (BEP 9, BEP 53 for `so`).
+type Magnet struct {
+	// InfoHash is the v1 SHA-1 info hash from `xt=urn:btih:`.
+	InfoHash []byte
+	// InfoHashV2 is the v2 SHA-256 info hash from `xt=urn:btmh:`.
+	InfoHashV2  []byte
+	DisplayName string
+	Length      int64
+	Trackers    []string
+	WebSeeds    []string
+	Peers       []string
+	// SelectOnly lists the file indexes from `so`, ranges expanded.
+	SelectOnly []int
+}
+
+const (
+	btihPrefix = "urn:btih:"
+	btmhPrefix = "urn:btmh:"
+	// multihash prefix for a 32-byte sha2-256 digest
+	sha256Multihash = "1220"
+)
+
+func ParseMagnet(uri string) (*Magnet, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %w", err)
+	}
+	if parsed.Scheme != "magnet" {
+		return nil, fmt.Errorf("invalid magnet link: scheme %q is not magnet", parsed.Scheme)
+	}
+	query, err := url.ParseQuery(parsed.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %w", err)
+	}
+
+	magnet := &Magnet{
+		DisplayName: query.Get("dn"),
+		Trackers:    query["tr"],
+		WebSeeds:    query["ws"],
+		Peers:       query["x.pe"],
+	}
+	for _, xt := range query["xt"] {
+		switch {
+		case strings.HasPrefix(xt, btihPrefix):
+			if magnet.InfoHash, err = decodeBTIH(xt[len(btihPrefix):]); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(xt, btmhPrefix):
+			if magnet.InfoHashV2, err = decodeBTMH(xt[len(btmhPrefix):]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if magnet.InfoHash == nil && magnet.InfoHashV2 == nil {
+		return nil, fmt.Errorf("invalid magnet link: no urn:btih or urn:btmh exact topic")
+	}
+	if xl := query.Get("xl"); xl != "" {
+		if magnet.Length, err = strconv.ParseInt(xl, 10, 64); err != nil || magnet.Length < 0 {
+			return nil, fmt.Errorf("invalid magnet link: bad exact length %q", xl)
+		}
+	}
+	if so := query.Get("so"); so != "" {
+		if magnet.SelectOnly, err = parseSelectOnly(so); err != nil {
+			return nil, err
+		}
+	}
+	return magnet, nil
+}
+
+// decodeBTIH accepts the 40-character hex and 32-character base32 forms.
+func decodeBTIH(encoded string) ([]byte, error) {
+	switch len(encoded) {
+	case 40:
+		infoHash, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid magnet link: bad hex info hash %q", encoded)
+		}
+		return infoHash, nil
+	case 32:
+		infoHash, err := base32.StdEncoding.DecodeString(strings.ToUpper(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid magnet link: bad base32 info hash %q", encoded)
+		}
+		return infoHash, nil
+	}
+	return nil, fmt.Errorf("invalid magnet link: info hash %q has length %d", encoded, len(encoded))
+}
+
+// decodeBTMH accepts a hex multihash, which must be sha2-256 for v2.
+func decodeBTMH(encoded string) ([]byte, error) {
+	if !strings.HasPrefix(strings.ToLower(encoded), sha256Multihash) || len(encoded) != len(sha256Multihash)+64 {
+		return nil, fmt.Errorf("invalid magnet link: %q is not a sha2-256 multihash", encoded)
+	}
+	infoHash, err := hex.DecodeString(encoded[len(sha256Multihash):])
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: bad hex multihash %q", encoded)
+	}
+	return infoHash, nil
+}
+
+// maxSelectOnly bounds how many file indexes `so` may expand to, well above
+// any real torrent's file count, so a hostile range can't exhaust memory.
+const maxSelectOnly = 100000
+
+// parseSelectOnly expands a BEP 53 list such as "0,2,4,6-8".
+func parseSelectOnly(so string) ([]int, error) {
+	var indexes []int
+	for _, part := range strings.Split(so, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid magnet link: bad file index %q in so", part)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("invalid magnet link: bad file range %q in so", part)
+			}
+		}
+		if last-first >= maxSelectOnly-len(indexes) {
+			return nil, fmt.Errorf("invalid magnet link: so selects more than %d files", maxSelectOnly)
+		}
+		for index := first; index <= last; index++ {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes, nil
+}
+
+// String re-encodes the magnet link.
+func (magnet *Magnet) String() string {
+	var params []string
+	if magnet.InfoHash != nil {
+		params = append(params, "xt="+btihPrefix+hex.EncodeToString(magnet.InfoHash))
+	}
+	if magnet.InfoHashV2 != nil {
+		params = append(params, "xt="+btmhPrefix+sha256Multihash+hex.EncodeToString(magnet.InfoHashV2))
+	}
+	if magnet.DisplayName != "" {
+		params = append(params, "dn="+url.QueryEscape(magnet.DisplayName))
+	}
+	if magnet.Length > 0 {
+		params = append(params, "xl="+strconv.FormatInt(magnet.Length, 10))
+	}
+	for _, tracker := range magnet.Trackers {
+		params = append(params, "tr="+url.QueryEscape(tracker))
+	}
+	for _, webSeed := range magnet.WebSeeds {
+		params = append(params, "ws="+url.QueryEscape(webSeed))
+	}
+	for _, peer := range magnet.Peers {
+		params = append(params, "x.pe="+url.QueryEscape(peer))
+	}
+	if len(magnet.SelectOnly) > 0 {
+		indexes := make([]string, len(magnet.SelectOnly))
+		for i, index := range magnet.SelectOnly {
+			indexes[i] = strconv.Itoa(index)
+		}
+		params = append(params, "so="+strings.Join(indexes, ","))
+	}
+	return "magnet:?" + strings.Join(params, "&")
+}
+
+// magnetPlaceholderLength is reported as `left` when announcing a magnet
+// without xl; trackers reject left=0 from a peer that has nothing.
+const magnetPlaceholderLength = 999
+
+// TrackerMeta returns a metadata-less TorrentFileMeta good enough to announce
+// the magnet's info hash to its trackers.
+func (magnet *Magnet) TrackerMeta() *TorrentFileMeta {
+	meta := &TorrentFileMeta{InfoHashBytes: magnet.WireInfoHash()}
+	if len(magnet.Trackers) > 0 {
+		meta.TorrentFileInfo.Announce = magnet.Trackers[0]
+		meta.TorrentFileInfo.AnnounceList = [][]string{magnet.Trackers}
+	}
+	meta.TorrentFileInfo.Info.Length = magnet.Length
+	if meta.TorrentFileInfo.Info.Length == 0 {
+		meta.TorrentFileInfo.Info.Length = magnetPlaceholderLength
+	}
+	return meta
+}
+
+// MetaFromMagnet builds the full TorrentFileMeta from an info dictionary
+// fetched with ut_metadata, carrying over the magnet's trackers.
+func MetaFromMagnet(magnet *Magnet, rawInfo []byte) (*TorrentFileMeta, error) {
+	torrentFile := TorrentFile{RawInfo: rawInfo}
+	if len(magnet.Trackers) > 0 {
+		torrentFile.Announce = magnet.Trackers[0]
+	}
+	if len(magnet.Trackers) > 1 {
+		torrentFile.AnnounceList = [][]string{magnet.Trackers}
+	}
+	torrentFile.URLList = magnet.WebSeeds
+	data, err := Marshal(torrentFile)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ParseTorrent(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	// hybrid torrents keep the v1 hash on the wire, so a btmh-only magnet
+	// can only be checked against the full v2 hash
+	if magnet.InfoHash != nil && !bytes.Equal(meta.InfoHashBytes, magnet.InfoHash) {
+		return nil, fmt.Errorf("metadata info hash %x does not match magnet %x", meta.InfoHashBytes, magnet.InfoHash)
+	}
+	if magnet.InfoHashV2 != nil && !bytes.Equal(meta.InfoHashV2, magnet.InfoHashV2) {
+		return nil, fmt.Errorf("metadata v2 info hash %x does not match magnet %x", meta.InfoHashV2, magnet.InfoHashV2)
+	}
+	return meta, nil
+}
+
+// WireInfoHash is the 20-byte hash peers and trackers know the torrent by:
+// the v1 info hash, or the truncated v2 one for v2-only magnets.
+func (magnet *Magnet) WireInfoHash() []byte {
+	if magnet.InfoHash != nil {
+		return magnet.InfoHash
+	}
+	if magnet.InfoHashV2 != nil {
+		return magnet.InfoHashV2[:20]
+	}
+	return nil
+}
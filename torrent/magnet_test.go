@@ -0,0 +1,65 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+// hybridInfo is the info dictionary of a one-block hybrid v1/v2 torrent.
+func hybridInfo(t *testing.T) []byte {
+	t.Helper()
+	data := bytes.Repeat([]byte("hybrid"), 1000)
+	piecesRoot := sha256.Sum256(data)
+	piece := sha1.Sum(data)
+	rawInfo, err := Marshal(map[string]interface{}{
+		"name":         "file.bin",
+		"length":       len(data),
+		"piece length": 16384,
+		"pieces":       string(piece[:]),
+		"meta version": 2,
+		"file tree": map[string]interface{}{
+			"file.bin": map[string]interface{}{
+				"": map[string]interface{}{"length": len(data), "pieces root": string(piecesRoot[:])},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rawInfo
+}
+
+func TestMetaFromMagnetHybrid(t *testing.T) {
+	rawInfo := hybridInfo(t)
+	v1 := sha1.Sum(rawInfo)
+	v2 := sha256.Sum256(rawInfo)
+	other := sha256.Sum256([]byte("other"))
+	tests := []struct {
+		name    string
+		uri     string
+		wantErr bool
+	}{
+		{"btih", fmt.Sprintf("magnet:?xt=urn:btih:%x", v1), false},
+		{"btmh", fmt.Sprintf("magnet:?xt=urn:btmh:1220%x", v2), false},
+		{"btih and btmh", fmt.Sprintf("magnet:?xt=urn:btih:%x&xt=urn:btmh:1220%x", v1, v2), false},
+		{"wrong btih", fmt.Sprintf("magnet:?xt=urn:btih:%x", other[:20]), true},
+		{"wrong btmh", fmt.Sprintf("magnet:?xt=urn:btmh:1220%x", other), true},
+	}
+	for _, test := range tests {
+		magnet, err := ParseMagnet(test.uri)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		meta, err := MetaFromMagnet(magnet, rawInfo)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: MetaFromMagnet error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && !bytes.Equal(meta.InfoHashBytes, v1[:]) {
+			t.Errorf("%s: wire info hash %x, want the v1 hash %x", test.name, meta.InfoHashBytes, v1)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+const (
+	MessageExtended = 20
+
+	// ExtensionHandshakeID is the extended message id of the BEP 10 handshake.
+	ExtensionHandshakeID = 0
+	// UtMetadataID is the id we ask peers to use for ut_metadata messages
+	// sent to us.
+	UtMetadataID = 16
+
+	// MetadataPieceSize is the BEP 9 metadata piece size.
+	MetadataPieceSize = 16 * 1024
+	// MaxMetadataSize caps the metadata_size a peer can claim. Real info
+	// dictionaries are far smaller; this stops a hostile peer from making
+	// us allocate arbitrary memory.
+	MaxMetadataSize = 8 * 1024 * 1024
+
+	// extensionReservedByte/Bit mark BEP 10 support in the handshake.
+	extensionReservedByte = 5
+	extensionReservedBit  = 0x10
+)
+
+const (
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+)
+
+type ExtensionHandshake struct {
+	M            map[string]int64 `bencode:"m"`
+	MetadataSize int64            `bencode:"metadata_size,omitempty"`
+	V            string           `bencode:"v,omitempty"`
+}
+
+type metadataMessage struct {
+	MsgType   int64 `bencode:"msg_type"`
+	Piece     int64 `bencode:"piece"`
+	TotalSize int64 `bencode:"total_size,omitempty"`
+}
+
+// PeerExtensions is what a peer told us in its extension handshake.
+type PeerExtensions struct {
+	PeerId       string
+	UtMetadataID int64
+	MetadataSize int64
+}
+
+// HandshakeExtended performs a handshake advertising extension protocol
+// support and reports whether the peer supports it too.
+func (client *Client) HandshakeExtended(peerAddress string, infohash []byte) (string, bool, error) {
+	var reserved [8]byte
+	reserved[extensionReservedByte] |= extensionReservedBit
+	reply, err := client.handshake(peerAddress, infohash, reserved)
+	if err != nil {
+		return "", false, err
+	}
+	supported := reply.Reserved[extensionReservedByte]&extensionReservedBit != 0
+	return fmt.Sprintf("%x", reply.PeerId), supported, nil
+}
+
+func (client *Client) SendExtended(peerAddress string, extendedID byte, payload []byte) error {
+	return client.SendMessage(peerAddress, MessageExtended, append([]byte{extendedID}, payload...))
+}
+
+// RecieveExtended waits for the next extended message, skipping the
+// bitfield, have and choke-state messages peers interleave with it.
+func (client *Client) RecieveExtended(peerAddress string) (byte, []byte, error) {
+	for {
+		messageType, message, err := client.RecieveMessage(peerAddress)
+		if err != nil {
+			return 0, nil, err
+		}
+		if messageType != MessageExtended {
+			continue
+		}
+		if len(message) == 0 {
+			return 0, nil, fmt.Errorf("empty extended message from %s", peerAddress)
+		}
+		return message[0], message[1:], nil
+	}
+}
+
+// ExchangeExtensionHandshake sends our BEP 10 handshake and reads the peer's.
+func (client *Client) ExchangeExtensionHandshake(peerAddress string) (*ExtensionHandshake, error) {
+	ours, err := bencode.Marshal(ExtensionHandshake{M: map[string]int64{"ut_metadata": UtMetadataID}})
+	if err != nil {
+		return nil, err
+	}
+	if err := client.SendExtended(peerAddress, ExtensionHandshakeID, ours); err != nil {
+		return nil, err
+	}
+	for {
+		extendedID, payload, err := client.RecieveExtended(peerAddress)
+		if err != nil {
+			return nil, err
+		}
+		if extendedID != ExtensionHandshakeID {
+			continue
+		}
+		var theirs ExtensionHandshake
+		if err := bencode.Unmarshal(payload, &theirs); err != nil {
+			return nil, fmt.Errorf("invalid extension handshake from %s: %w", peerAddress, err)
+		}
+		return &theirs, nil
+	}
+}
+
+// ConnectExtended dials peerAddress and completes both the BitTorrent and
+// extension handshakes. The connection stays open; call Close when done.
+func (client *Client) ConnectExtended(peerAddress string, infohash []byte) (*PeerExtensions, error) {
+	if err := client.Dial(peerAddress); err != nil {
+		return nil, err
+	}
+	peerId, supported, err := client.HandshakeExtended(peerAddress, infohash)
+	if err != nil {
+		client.Close(peerAddress)
+		return nil, err
+	}
+	extensions := &PeerExtensions{PeerId: peerId}
+	if !supported {
+		return extensions, nil
+	}
+	handshake, err := client.ExchangeExtensionHandshake(peerAddress)
+	if err != nil {
+		client.Close(peerAddress)
+		return nil, err
+	}
+	extensions.UtMetadataID = handshake.M["ut_metadata"]
+	extensions.MetadataSize = handshake.MetadataSize
+	return extensions, nil
+}
+
+// FetchMetadata downloads the info dictionary from a peer with ut_metadata
+// (BEP 9) and checks it against infohash.
+func (client *Client) FetchMetadata(peerAddress string, infohash []byte) ([]byte, error) {
+	extensions, err := client.ConnectExtended(peerAddress, infohash)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close(peerAddress)
+	if extensions.UtMetadataID <= 0 || extensions.UtMetadataID > 255 {
+		return nil, fmt.Errorf("peer %s does not support ut_metadata", peerAddress)
+	}
+
+	size := extensions.MetadataSize
+	var metadata []byte
+	for piece := int64(0); piece == 0 || piece*MetadataPieceSize < size; piece++ {
+		request, err := bencode.Marshal(metadataMessage{MsgType: metadataRequest, Piece: piece})
+		if err != nil {
+			return nil, err
+		}
+		if err := client.SendExtended(peerAddress, byte(extensions.UtMetadataID), request); err != nil {
+			return nil, err
+		}
+		message, data, err := client.recieveMetadataPiece(peerAddress)
+		if err != nil {
+			return nil, err
+		}
+		if message.MsgType == metadataReject {
+			return nil, fmt.Errorf("peer %s rejected metadata piece %d", peerAddress, piece)
+		}
+		if message.Piece != piece {
+			return nil, fmt.Errorf("peer %s sent metadata piece %d, want %d", peerAddress, message.Piece, piece)
+		}
+		if piece == 0 {
+			if size == 0 {
+				size = message.TotalSize
+			}
+			if size <= 0 || size > MaxMetadataSize {
+				return nil, fmt.Errorf("peer %s claims metadata size %d (limit %d)", peerAddress, size, MaxMetadataSize)
+			}
+			metadata = make([]byte, 0, size)
+		}
+		want := size - piece*MetadataPieceSize
+		if want > MetadataPieceSize {
+			want = MetadataPieceSize
+		}
+		if int64(len(data)) != want {
+			return nil, fmt.Errorf("peer %s sent %d bytes for metadata piece %d, want %d", peerAddress, len(data), piece, want)
+		}
+		metadata = append(metadata, data...)
+	}
+
+	// v2 swarms use the truncated SHA-256 as the wire info hash
+	hash, hashV2 := sha1.Sum(metadata), sha256.Sum256(metadata)
+	if !bytes.Equal(hash[:], infohash) && !bytes.Equal(hashV2[:sha1.Size], infohash) {
+		return nil, fmt.Errorf("metadata from %s does not match info hash %x", peerAddress, infohash)
+	}
+	return metadata, nil
+}
+
+// recieveMetadataPiece reads the next ut_metadata message. Data messages
+// carry the piece bytes straight after the bencoded dictionary.
+func (client *Client) recieveMetadataPiece(peerAddress string) (*metadataMessage, []byte, error) {
+	for {
+		extendedID, payload, err := client.RecieveExtended(peerAddress)
+		if err != nil {
+			return nil, nil, err
+		}
+		if extendedID != UtMetadataID {
+			continue
+		}
+		decoder := bencode.NewDecoder(bytes.NewReader(payload))
+		var message metadataMessage
+		if err := decoder.Decode(&message); err != nil {
+			return nil, nil, fmt.Errorf("invalid ut_metadata message from %s: %w", peerAddress, err)
+		}
+		if message.MsgType == metadataRequest {
+			// we don't serve metadata yet
+			continue
+		}
+		return &message, payload[decoder.Offset():], nil
+	}
+}
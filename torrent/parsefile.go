@@ -1,45 +1,59 @@
 package torrent
 
 import (
-	"bytes"
 	"crypto/sha1"
+	"fmt"
 	"os"
 
-	bencode "github.com/jackpal/bencode-go" // Available if you need it!
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
 )
 
 func ParseTorrentFile(filename string) (*TorrentFileMeta, error) {
-	file, err := os.Open(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return &TorrentFileMeta{}, err
 	}
-	defer file.Close()
 
+	meta, err := ParseTorrent(data)
+	if err != nil {
+		return &TorrentFileMeta{}, fmt.Errorf("unable to parse torrent file %s: %w", filename, err)
+	}
+	return meta, nil
+}
+
+// ParseTorrent parses bencoded metainfo. The info hash is computed over the
+// original bytes of the info dictionary, not a re-encoding of TorrentFileInfo.
+func ParseTorrent(data []byte) (*TorrentFileMeta, error) {
 	info := TorrentFile{}
-	if err := bencode.Unmarshal(file, &info); err == nil {
-		if infoHashBytes, err := torrentInfoHash(&info); err == nil {
-			meta := &TorrentFileMeta{
-				TorrentFileInfo: info,
-				InfoHashBytes:   infoHashBytes,
-			}
-			return meta, nil
-		} else {
-			return &TorrentFileMeta{}, err
-		}
-	} else {
+	if err := bencode.Unmarshal(data, &info); err != nil {
 		return &TorrentFileMeta{}, err
 	}
+	if len(info.RawInfo) == 0 {
+		return &TorrentFileMeta{}, fmt.Errorf("missing info dictionary")
+	}
+	if err := bencode.Unmarshal(info.RawInfo, &info.Info); err != nil {
+		return &TorrentFileMeta{}, fmt.Errorf("invalid info dictionary: %w", err)
+	}
+	meta := &TorrentFileMeta{
+		TorrentFileInfo: info,
+		InfoHashBytes:   torrentInfoHash(&info),
+	}
+	if err := meta.checkSizes(); err != nil {
+		return &TorrentFileMeta{}, fmt.Errorf("invalid info dictionary: %w", err)
+	}
+	if meta.IsV2() {
+		if err := meta.checkV2(); err != nil {
+			return &TorrentFileMeta{}, fmt.Errorf("invalid info dictionary: %w", err)
+		}
+		meta.InfoHashV2 = infoHashV2(info.RawInfo)
+		if !meta.IsV1() {
+			meta.InfoHashBytes = meta.InfoHashV2[:sha1.Size]
+		}
+	}
+	return meta, nil
 }
 
-func torrentInfoHash(torrentFile *TorrentFile) ([]byte, error) {
-	var buf bytes.Buffer
-	marshalErr := bencode.Marshal(&buf, torrentFile.Info)
-	if marshalErr != nil {
-		return nil, marshalErr
-	}
-	hasher := sha1.New()
-	hasher.Write(buf.Bytes())
-	// shaInfo := base64.URLEncoding.EncodeToString(hasher.Sum(nil))
-	shaInfo := hasher.Sum(nil)
-	return shaInfo, nil
+func torrentInfoHash(torrentFile *TorrentFile) []byte {
+	shaInfo := sha1.Sum(torrentFile.RawInfo)
+	return shaInfo[:]
 }
@@ -0,0 +1,44 @@
+package torrent
+
+import (
+	"strings"
+	"testing"
+)
+
+func testTorrent(t *testing.T, length int64, pieces int) []byte {
+	t.Helper()
+	data, err := Marshal(map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"info": map[string]interface{}{
+			"name":         "file.bin",
+			"length":       length,
+			"piece length": 16384,
+			"pieces":       strings.Repeat("x", pieces*PieceHashSize),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseTorrentPieceCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		length  int64
+		pieces  int
+		wantErr bool
+	}{
+		{"exact", 16384 + 10, 2, false},
+		{"one short piece", 10, 1, false},
+		{"too many hashes", 10, 2, true},
+		{"too few hashes", 3 * 16384, 2, true},
+		{"no hashes", 10, 0, true},
+	}
+	for _, test := range tests {
+		_, err := ParseTorrent(testTorrent(t, test.length, test.pieces))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: ParseTorrent error = %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
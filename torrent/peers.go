@@ -1,23 +1,115 @@
 package torrent
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
 	"github.com/dghubble/sling"
-	"github.com/jackpal/bencode-go"
 )
 
+// trackerHTTPClient bounds how long a dead tracker can hold up failover to
+// the next one.
+var trackerHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
 func GetPeers(meta *TorrentFileMeta) (TrackerResponse, error) {
-	params := DefaultTrackerClientParams(string(meta.InfoHashBytes), meta.TorrentFileInfo.Info.Length)
-	req, err := sling.New().Get(meta.TorrentFileInfo.Announce).QueryStruct(params).Request()
+	params := DefaultTrackerClientParams(string(meta.InfoHashBytes), meta.TotalLength())
+	trackerResp, _, err := NewTrackerTiers(meta).Announce(func(trackerURL string) (TrackerResponse, error) {
+		return announce(trackerURL, params)
+	})
+	return trackerResp, err
+}
+
+// announce picks the tracker protocol from the URL scheme.
+func announce(trackerURL string, params *TrackerClientParams) (TrackerResponse, error) {
+	parsed, err := url.Parse(trackerURL)
+	if err != nil {
+		return TrackerResponse{}, err
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		return announceHTTP(trackerURL, params)
+	case "udp":
+		return defaultUDPTracker.Announce(parsed.Host, params)
+	}
+	return TrackerResponse{}, fmt.Errorf("unsupported tracker scheme %q", parsed.Scheme)
+}
+
+// ScrapeStats is a tracker's view of one swarm.
+type ScrapeStats struct {
+	Complete   int `bencode:"complete"`
+	Downloaded int `bencode:"downloaded"`
+	Incomplete int `bencode:"incomplete"`
+}
+
+// Scrape asks the tracker for the stats of each info hash, picking the
+// protocol from the URL scheme.
+func Scrape(trackerURL string, infoHashes [][]byte) ([]ScrapeStats, error) {
+	parsed, err := url.Parse(trackerURL)
+	if err != nil {
+		return nil, err
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		return scrapeHTTP(parsed, infoHashes)
+	case "udp":
+		return defaultUDPTracker.Scrape(parsed.Host, infoHashes)
+	}
+	return nil, fmt.Errorf("unsupported tracker scheme %q", parsed.Scheme)
+}
+
+// scrapeHTTP uses the scrape convention: the announce URL with its last
+// path component "announce" replaced by "scrape".
+func scrapeHTTP(announceURL *url.URL, infoHashes [][]byte) ([]ScrapeStats, error) {
+	scrapeURL := *announceURL
+	slash := strings.LastIndex(scrapeURL.Path, "/")
+	if !strings.HasPrefix(scrapeURL.Path[slash+1:], "announce") {
+		return nil, fmt.Errorf("tracker %s does not support scrape", announceURL)
+	}
+	scrapeURL.Path = scrapeURL.Path[:slash+1] + "scrape" + strings.TrimPrefix(scrapeURL.Path[slash+1:], "announce")
+	query := scrapeURL.Query()
+	for _, infoHash := range infoHashes {
+		query.Add("info_hash", string(infoHash))
+	}
+	scrapeURL.RawQuery = query.Encode()
+
+	res, err := trackerHTTPClient.Get(scrapeURL.String())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var scrapeResp struct {
+		FailureReason string                 `bencode:"failure reason,omitempty"`
+		Files         map[string]ScrapeStats `bencode:"files"`
+	}
+	if err := bencode.Unmarshal(body, &scrapeResp); err != nil {
+		return nil, fmt.Errorf("invalid scrape response: %w", err)
+	}
+	if scrapeResp.FailureReason != "" {
+		return nil, fmt.Errorf("tracker failure: %s", scrapeResp.FailureReason)
+	}
+	stats := make([]ScrapeStats, len(infoHashes))
+	for i, infoHash := range infoHashes {
+		stats[i] = scrapeResp.Files[string(infoHash)]
+	}
+	return stats, nil
+}
+
+func announceHTTP(trackerURL string, params *TrackerClientParams) (TrackerResponse, error) {
+	req, err := sling.New().Get(trackerURL).QueryStruct(params).Request()
 	if err != nil {
 		return TrackerResponse{}, err
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := trackerHTTPClient.Do(req)
 	if err != nil {
 		fmt.Printf("client: error making http request: %s\n", err)
 		return TrackerResponse{}, err
@@ -30,9 +122,16 @@ func GetPeers(meta *TorrentFileMeta) (TrackerResponse, error) {
 	}
 	// fmt.Printf("client: response body: %s\n", resBody)
 
+	return decodeTrackerResponse(resBody)
+}
+
+func decodeTrackerResponse(body []byte) (TrackerResponse, error) {
 	var trackerResp TrackerResponse
-	if err := bencode.Unmarshal(bytes.NewReader(resBody), &trackerResp); err != nil {
-		return TrackerResponse{}, err
+	if err := bencode.Unmarshal(body, &trackerResp); err != nil {
+		return TrackerResponse{}, fmt.Errorf("invalid tracker response: %w", err)
+	}
+	if trackerResp.FailureReason != "" {
+		return TrackerResponse{}, fmt.Errorf("tracker failure: %s", trackerResp.FailureReason)
 	}
 	return trackerResp, nil
 }
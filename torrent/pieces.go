@@ -0,0 +1,231 @@
+package torrent
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+)
+
+const PieceHashSize = 20
+
+// TotalLength is the number of content bytes covered by the pieces.
+func (meta *TorrentFileMeta) TotalLength() int64 {
+	if !meta.IsV1() {
+		var total int64
+		for _, file := range meta.filesV2() {
+			total += file.Length
+		}
+		return total
+	}
+	if !meta.IsMultiFile() {
+		return meta.TorrentFileInfo.Info.Length
+	}
+	var total int64
+	for _, file := range meta.TorrentFileInfo.Info.Files {
+		total += file.Length
+	}
+	return total
+}
+
+func (meta *TorrentFileMeta) PieceLength() int64 {
+	return meta.TorrentFileInfo.Info.PieceLength
+}
+
+// NumPieces is derived from the piece hashes, which are authoritative.
+func (meta *TorrentFileMeta) NumPieces() int {
+	return len(meta.TorrentFileInfo.Info.Pieces)
+}
+
+// PieceOffset is the offset of piece i within the concatenated content.
+func (meta *TorrentFileMeta) PieceOffset(i int) int64 {
+	return int64(i) * meta.PieceLength()
+}
+
+// PieceSize is the length of piece i; only the last piece may be short.
+func (meta *TorrentFileMeta) PieceSize(i int) int64 {
+	offset := meta.PieceOffset(i)
+	if remaining := meta.TotalLength() - offset; remaining < meta.PieceLength() {
+		return remaining
+	}
+	return meta.PieceLength()
+}
+
+// PieceHash is the expected SHA-1 of piece i.
+func (meta *TorrentFileMeta) PieceHash(i int) []byte {
+	return meta.TorrentFileInfo.Info.Pieces[i][:]
+}
+
+func (meta *TorrentFileMeta) checkPieceIndex(i int) error {
+	if i < 0 || i >= meta.NumPieces() {
+		return fmt.Errorf("piece index %d out of range [0, %d)", i, meta.NumPieces())
+	}
+	return nil
+}
+
+// checkSizes rejects lengths that are negative or whose piece arithmetic
+// would overflow int64.
+func (meta *TorrentFileMeta) checkSizes() error {
+	info := meta.TorrentFileInfo.Info
+	if info.Length < 0 {
+		return fmt.Errorf("negative length %d", info.Length)
+	}
+	var total int64
+	for i, file := range info.Files {
+		if file.Length < 0 {
+			return fmt.Errorf("file %d: negative length %d", i, file.Length)
+		}
+		if file.Length > math.MaxInt64-total {
+			return fmt.Errorf("file %d: total length overflows", i)
+		}
+		total += file.Length
+	}
+	if info.PieceLength <= 0 {
+		return fmt.Errorf("invalid piece length %d", info.PieceLength)
+	}
+	if info.PieceLength > math.MaxUint32 {
+		return fmt.Errorf("piece length %d does not fit the wire protocol", info.PieceLength)
+	}
+	if numPieces := int64(meta.NumPieces()); numPieces > 0 && info.PieceLength > math.MaxInt64/numPieces {
+		return fmt.Errorf("piece length %d overflows with %d pieces", info.PieceLength, numPieces)
+	}
+	if meta.IsV1() {
+		total = meta.TotalLength()
+		want := total / info.PieceLength
+		if total%info.PieceLength != 0 {
+			want++
+		}
+		if int64(meta.NumPieces()) != want {
+			return fmt.Errorf("%d piece hashes for %d bytes, want %d", meta.NumPieces(), total, want)
+		}
+	}
+	return nil
+}
+
+// pieceSpan locates a piece within storage. For pure v2 torrents pieces are
+// aligned to files, so file and fileIndex place it within its file.
+type pieceSpan struct {
+	offset    int64
+	length    int64
+	file      int
+	fileIndex int
+}
+
+// pieceLayout is the torrent's files and piece spans. Building it walks
+// every file and piece, so it is built once per torrent by pieceLayout.
+type pieceLayout struct {
+	files []FileEntry
+	spans []pieceSpan
+}
+
+// span returns the span of piece index, as numbered by pieceSpans.
+func (layout *pieceLayout) span(index int) (pieceSpan, error) {
+	if index < 0 || index >= len(layout.spans) {
+		return pieceSpan{}, fmt.Errorf("piece index %d out of range [0, %d)", index, len(layout.spans))
+	}
+	return layout.spans[index], nil
+}
+
+func (meta *TorrentFileMeta) pieceLayout() (*pieceLayout, error) {
+	meta.layoutMu.Lock()
+	defer meta.layoutMu.Unlock()
+	if meta.layout == nil {
+		spans, err := meta.buildPieceSpans()
+		if err != nil {
+			return nil, err
+		}
+		meta.layout = &pieceLayout{files: meta.Files(), spans: spans}
+	}
+	return meta.layout, nil
+}
+
+// pieceSpans lists the pieces that make up the content: the v1 pieces, or
+// for pure v2 torrents each file's pieces in file order. Every span is
+// checked to be non-empty and at most one piece long, so callers can size
+// buffers from it. The list is shared and must not be modified.
+func (meta *TorrentFileMeta) pieceSpans() ([]pieceSpan, error) {
+	layout, err := meta.pieceLayout()
+	if err != nil {
+		return nil, err
+	}
+	return layout.spans, nil
+}
+
+func (meta *TorrentFileMeta) buildPieceSpans() ([]pieceSpan, error) {
+	var spans []pieceSpan
+	if meta.IsV1() {
+		for index := 0; index < meta.NumPieces(); index++ {
+			spans = append(spans, pieceSpan{offset: meta.PieceOffset(index), length: meta.PieceSize(index)})
+		}
+		return spans, meta.checkSpans(spans)
+	}
+	for i, file := range meta.Files() {
+		for index := 0; index < meta.V2PieceCount(file); index++ {
+			span := pieceSpan{offset: file.Offset + int64(index)*meta.PieceLength(), length: meta.PieceLength(), file: i, fileIndex: index}
+			if remaining := file.Length - int64(index)*meta.PieceLength(); remaining < span.length {
+				span.length = remaining
+			}
+			spans = append(spans, span)
+		}
+	}
+	return spans, meta.checkSpans(spans)
+}
+
+func (meta *TorrentFileMeta) checkSpans(spans []pieceSpan) error {
+	for index, span := range spans {
+		if span.length <= 0 || span.length > meta.PieceLength() {
+			return fmt.Errorf("piece %d has invalid length %d", index, span.length)
+		}
+	}
+	return nil
+}
+
+// readPieces reads every piece listed by pieceSpans from storage on several
+// goroutines and calls fn with its contents or the read error. fn runs
+// concurrently and piece is only valid for the duration of the call; the
+// first error fn returns stops the run and is returned.
+func readPieces(meta *TorrentFileMeta, storage Storage, workers int, fn func(index int, piece []byte, err error) error) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	spans, err := meta.pieceSpans()
+	if err != nil {
+		return err
+	}
+	indexes := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, meta.PieceLength())
+			for index := range indexes {
+				piece := buf[:spans[index].length]
+				_, err := storage.ReadAt(piece, spans[index].offset)
+				if err := fn(index, piece, err); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for index := range spans {
+		select {
+		case indexes <- index:
+		case err = <-errs:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return err
+}
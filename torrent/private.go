@@ -0,0 +1,62 @@
+package torrent
+
+import "fmt"
+
+// PeerSource is where a peer address came from.
+type PeerSource int
+
+const (
+	PeerSourceTracker PeerSource = iota
+	PeerSourceDHT
+	PeerSourcePEX
+	PeerSourceLSD
+	// PeerSourceManual covers peers given by the user or a magnet's x.pe.
+	PeerSourceManual
+)
+
+func (source PeerSource) String() string {
+	switch source {
+	case PeerSourceTracker:
+		return "tracker"
+	case PeerSourceDHT:
+		return "dht"
+	case PeerSourcePEX:
+		return "pex"
+	case PeerSourceLSD:
+		return "lsd"
+	case PeerSourceManual:
+		return "manual"
+	}
+	return "unknown"
+}
+
+// IsPrivate reports the BEP 27 private flag.
+func (meta *TorrentFileMeta) IsPrivate() bool {
+	return meta.TorrentFileInfo.Info.Private
+}
+
+// AllowsPeerSource applies BEP 27: a private torrent only gets peers from
+// its trackers, never from DHT, PEX, LSD or anywhere else.
+func (meta *TorrentFileMeta) AllowsPeerSource(source PeerSource) bool {
+	return !meta.IsPrivate() || source == PeerSourceTracker
+}
+
+// FilterPeers drops peers from sources the client's torrent doesn't allow.
+func (client *Client) FilterPeers(source PeerSource, peers []string) []string {
+	if client.Meta == nil || client.Meta.AllowsPeerSource(source) {
+		return peers
+	}
+	if len(peers) > 0 {
+		fmt.Printf("Ignoring %d %s peers for private torrent\n", len(peers), source)
+	}
+	return nil
+}
+
+// MagnetPeers lists magnet's x.pe peers followed by trackerPeers, less any
+// the client's torrent doesn't allow. Until the metadata arrives the client
+// can't know the torrent is private, so call it again once client.Meta is
+// set.
+func (client *Client) MagnetPeers(magnet *Magnet, trackerPeers []string) []string {
+	peers := append([]string(nil), client.FilterPeers(PeerSourceManual, magnet.Peers)...)
+	return append(peers, client.FilterPeers(PeerSourceTracker, trackerPeers)...)
+}
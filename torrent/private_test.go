@@ -0,0 +1,25 @@
+package torrent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMagnetPeersPrivate(t *testing.T) {
+	meta, err := ParseTorrent(testTorrent(t, 10, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	magnet := &Magnet{Peers: []string{"10.0.0.1:6881", "10.0.0.2:6881"}}
+	trackerPeers := []string{"10.0.0.3:6881"}
+	client := NewClient(meta, &Config{})
+
+	all := []string{"10.0.0.1:6881", "10.0.0.2:6881", "10.0.0.3:6881"}
+	if peers := client.MagnetPeers(magnet, trackerPeers); !reflect.DeepEqual(peers, all) {
+		t.Errorf("public torrent: got %v, want %v", peers, all)
+	}
+	meta.TorrentFileInfo.Info.Private = true
+	if peers := client.MagnetPeers(magnet, trackerPeers); !reflect.DeepEqual(peers, trackerPeers) {
+		t.Errorf("private torrent: got %v, want only tracker peers %v", peers, trackerPeers)
+	}
+}
@@ -0,0 +1,176 @@
+package torrent
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+// QueryResult is one value matched by a query, with its concrete path.
+type QueryResult struct {
+	Path  string
+	Value interface{}
+}
+
+type pathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Query evaluates a path against bencoded data. Paths are dotted keys with
+// optional list indexes, e.g. `info.files[3].path` or `announce-list[0][0]`.
+// Keys containing dots or brackets can be written as `["piece length"]`.
+// `[*]` matches every list element, `*` every dictionary value, and negative
+// indexes count from the end of a list.
+func Query(data []byte, path string) ([]QueryResult, error) {
+	value, err := bencode.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return QueryValue(value, path)
+}
+
+// QueryValue is Query over an already decoded value.
+func QueryValue(value interface{}, path string) ([]QueryResult, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	results := []QueryResult{{Path: "", Value: value}}
+	for _, step := range steps {
+		var next []QueryResult
+		for _, result := range results {
+			matches, err := step.apply(result)
+			if err != nil && !hasWildcard(steps) {
+				return nil, err
+			}
+			next = append(next, matches...)
+		}
+		results = next
+	}
+	return results, nil
+}
+
+func hasWildcard(steps []pathStep) bool {
+	for _, step := range steps {
+		if step.wildcard {
+			return true
+		}
+	}
+	return false
+}
+
+func (step pathStep) apply(result QueryResult) ([]QueryResult, error) {
+	switch v := result.Value.(type) {
+	case []interface{}:
+		if !step.isIndex {
+			return nil, fmt.Errorf("%s is a list, not a dictionary", pathOrRoot(result.Path))
+		}
+		if step.wildcard {
+			matches := make([]QueryResult, len(v))
+			for i, elem := range v {
+				matches[i] = QueryResult{Path: fmt.Sprintf("%s[%d]", result.Path, i), Value: elem}
+			}
+			return matches, nil
+		}
+		index := step.index
+		if index < 0 {
+			index += len(v)
+		}
+		if index < 0 || index >= len(v) {
+			return nil, fmt.Errorf("%s: index %d out of range (length %d)", pathOrRoot(result.Path), step.index, len(v))
+		}
+		return []QueryResult{{Path: fmt.Sprintf("%s[%d]", result.Path, index), Value: v[index]}}, nil
+	case map[string]interface{}:
+		if step.isIndex {
+			return nil, fmt.Errorf("%s is a dictionary, not a list", pathOrRoot(result.Path))
+		}
+		if step.wildcard {
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			matches := make([]QueryResult, len(keys))
+			for i, key := range keys {
+				matches[i] = QueryResult{Path: pathKey(result.Path, key), Value: v[key]}
+			}
+			return matches, nil
+		}
+		elem, ok := v[step.key]
+		if !ok {
+			return nil, fmt.Errorf("%s: no key %q", pathOrRoot(result.Path), step.key)
+		}
+		return []QueryResult{{Path: pathKey(result.Path, step.key), Value: elem}}, nil
+	default:
+		return nil, fmt.Errorf("%s is not a list or dictionary", pathOrRoot(result.Path))
+	}
+}
+
+// pathKey appends key to path, bracket-quoting keys that the path syntax
+// can't express bare.
+func pathKey(path, key string) string {
+	if key == "" || key == "*" || strings.ContainsAny(key, ".[]\"") {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	return joinPath(path, key)
+}
+
+func parsePath(path string) ([]pathStep, error) {
+	var steps []pathStep
+	i := 0
+	for i < len(path) {
+		switch c := path[i]; {
+		case c == '.':
+			if i == 0 || i == len(path)-1 || path[i+1] == '.' || path[i+1] == '[' {
+				return nil, fmt.Errorf("invalid path %q: misplaced '.' at %d", path, i)
+			}
+			i++
+		case c == '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unclosed '[' at %d", path, i)
+			}
+			inner := path[i+1 : i+end]
+			if strings.HasPrefix(inner, "\"") {
+				// quoted keys may themselves contain ']'
+				closing := strings.Index(path[i+2:], "\"]")
+				if closing < 0 {
+					return nil, fmt.Errorf("invalid path %q: unterminated quoted key at %d", path, i)
+				}
+				quoted := path[i+1 : i+2+closing+1]
+				key, err := strconv.Unquote(quoted)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %q: bad quoted key at %d", path, i)
+				}
+				steps = append(steps, pathStep{key: key})
+				i += len(quoted) + 2
+				continue
+			}
+			if inner == "*" {
+				steps = append(steps, pathStep{isIndex: true, wildcard: true})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %q: bad index %q at %d", path, inner, i)
+				}
+				steps = append(steps, pathStep{isIndex: true, index: index})
+			}
+			i += end + 1
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			key := path[i : i+end]
+			steps = append(steps, pathStep{key: key, wildcard: key == "*"})
+			i += end
+		}
+	}
+	return steps, nil
+}
@@ -0,0 +1,263 @@
+package torrent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Storage holds the concatenated content of a torrent, addressed by the
+// same offsets as pieces.
+type Storage interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+type storageFile struct {
+	path   string
+	offset int64
+	length int64
+	handle *os.File
+	// padding files read as zeros and are never written to disk
+	padding    bool
+	executable bool
+	// symlinkTarget is the absolute target of a BEP 47 symlink
+	symlinkTarget string
+}
+
+// FileStorage maps piece offsets onto files on disk, splitting reads and
+// writes that span file boundaries.
+type FileStorage struct {
+	mu    sync.Mutex // guards opening handles
+	files []*storageFile
+	flag  int
+}
+
+// NewFileStorage creates the files of meta for writing. A single-file
+// torrent is stored at outputPath itself; a multi-file torrent is laid out
+// under outputPath/<name>/. Existing files are kept, so their data can be
+// reused, but are truncated to the torrent's file lengths.
+func NewFileStorage(meta *TorrentFileMeta, outputPath string) (*FileStorage, error) {
+	storage, err := newFileStorage(meta, contentPath(meta, outputPath), os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range storage.files {
+		if file.padding {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+			return nil, err
+		}
+		if file.symlinkTarget != "" {
+			continue
+		}
+		handle, err := os.OpenFile(file.path, storage.flag, 0644)
+		if err != nil {
+			return nil, err
+		}
+		// drop whatever an earlier, larger file left past the end
+		err = handle.Truncate(file.length)
+		handle.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return storage, nil
+}
+
+// OpenFileStorage opens existing data read-only, with the same layout as
+// NewFileStorage. For a multi-file torrent outputPath may also name the
+// content directory itself when outputPath/<name> does not exist.
+func OpenFileStorage(meta *TorrentFileMeta, outputPath string) (*FileStorage, error) {
+	content := contentPath(meta, outputPath)
+	if stat, err := os.Stat(content); meta.IsMultiFile() && (err != nil || !stat.IsDir()) {
+		content = outputPath
+	}
+	return newFileStorage(meta, content, os.O_RDONLY)
+}
+
+func contentPath(meta *TorrentFileMeta, outputPath string) string {
+	if meta.IsMultiFile() {
+		return filepath.Join(outputPath, meta.TorrentFileInfo.Info.Name)
+	}
+	return outputPath
+}
+
+// newFileStorage maps meta's files onto content, which is the file itself
+// for a single-file torrent and the directory holding the files otherwise.
+func newFileStorage(meta *TorrentFileMeta, content string, flag int) (*FileStorage, error) {
+	if err := meta.checkPaths(); err != nil {
+		return nil, err
+	}
+	storage := &FileStorage{flag: flag}
+	for _, entry := range meta.Files() {
+		filePath := content
+		if meta.IsMultiFile() {
+			filePath = filepath.Join(append([]string{content}, entry.Path[1:]...)...)
+		}
+		file := &storageFile{
+			path:       filePath,
+			offset:     entry.Offset,
+			length:     entry.Length,
+			padding:    entry.IsPadding(),
+			executable: entry.IsExecutable(),
+		}
+		if entry.IsSymlink() && meta.IsMultiFile() {
+			file.symlinkTarget = filepath.Join(append([]string{content}, entry.SymlinkPath...)...)
+		}
+		storage.files = append(storage.files, file)
+	}
+	return storage, nil
+}
+
+func (storage *FileStorage) open(file *storageFile) (*os.File, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if file.handle == nil {
+		handle, err := os.OpenFile(file.path, storage.flag, 0644)
+		if err != nil {
+			return nil, err
+		}
+		file.handle = handle
+	}
+	return file.handle, nil
+}
+
+// span calls fn for each file overlapping [off, off+length), with the
+// offset within that file and the matching range of the buffer.
+func (storage *FileStorage) span(off int64, length int, fn func(file *storageFile, fileOff int64, lo, hi int) error) error {
+	end := off + int64(length)
+	for _, file := range storage.files {
+		fileEnd := file.offset + file.length
+		if fileEnd <= off || file.offset >= end || file.length == 0 {
+			continue
+		}
+		lo, hi := off, end
+		if lo < file.offset {
+			lo = file.offset
+		}
+		if hi > fileEnd {
+			hi = fileEnd
+		}
+		if err := fn(file, lo-file.offset, int(lo-off), int(hi-off)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (storage *FileStorage) totalLength() int64 {
+	if len(storage.files) == 0 {
+		return 0
+	}
+	last := storage.files[len(storage.files)-1]
+	return last.offset + last.length
+}
+
+func (storage *FileStorage) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > storage.totalLength() {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside the torrent", len(p), off)
+	}
+	written := 0
+	err := storage.span(off, len(p), func(file *storageFile, fileOff int64, lo, hi int) error {
+		if file.padding {
+			written += hi - lo
+			return nil
+		}
+		handle, err := storage.open(file)
+		if err != nil {
+			return err
+		}
+		n, err := handle.WriteAt(p[lo:hi], fileOff)
+		written += n
+		return err
+	})
+	return written, err
+}
+
+func (storage *FileStorage) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= storage.totalLength() {
+		return 0, io.EOF
+	}
+	length := len(p)
+	if remaining := storage.totalLength() - off; int64(length) > remaining {
+		length = int(remaining)
+	}
+	read := 0
+	err := storage.span(off, length, func(file *storageFile, fileOff int64, lo, hi int) error {
+		if file.padding {
+			for i := lo; i < hi; i++ {
+				p[i] = 0
+			}
+			read += hi - lo
+			return nil
+		}
+		handle, err := storage.open(file)
+		if err != nil {
+			return err
+		}
+		n, err := handle.ReadAt(p[lo:hi], fileOff)
+		read += n
+		if err == io.EOF && n < hi-lo {
+			return io.ErrUnexpectedEOF
+		}
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	})
+	if err == nil && read < len(p) {
+		err = io.EOF
+	}
+	return read, err
+}
+
+// Finalize applies BEP 47 attributes once the content is complete: it sets
+// the executable bits and creates symlinks, relative so the download can be
+// moved.
+func (storage *FileStorage) Finalize() error {
+	for _, file := range storage.files {
+		switch {
+		case file.symlinkTarget != "":
+			target, err := filepath.Rel(filepath.Dir(file.path), file.symlinkTarget)
+			if err != nil {
+				return err
+			}
+			if stat, err := os.Lstat(file.path); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+				os.Remove(file.path)
+			}
+			if err := os.Symlink(target, file.path); err != nil {
+				return err
+			}
+		case file.executable && !file.padding:
+			stat, err := os.Stat(file.path)
+			if err != nil {
+				return err
+			}
+			if err := os.Chmod(file.path, stat.Mode()|0111); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (storage *FileStorage) Close() error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	var firstErr error
+	for _, file := range storage.files {
+		if file.handle == nil {
+			continue
+		}
+		if err := file.handle.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		file.handle = nil
+	}
+	return firstErr
+}
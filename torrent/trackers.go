@@ -0,0 +1,92 @@
+package torrent
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrackerTiers implements BEP 12 tracker selection over announce-list.
+// Trackers are shuffled within each tier once, tiers are tried in order, and
+// a tracker that answers is moved to the front of its tier so it is tried
+// first next time.
+type TrackerTiers struct {
+	mu    sync.Mutex
+	tiers [][]string
+}
+
+// Trackers returns the announce tiers: announce-list when present (BEP 12
+// says announce is then ignored), otherwise announce as a single tier.
+func (meta *TorrentFileMeta) Trackers() [][]string {
+	var tiers [][]string
+	for _, tier := range meta.TorrentFileInfo.AnnounceList {
+		var urls []string
+		for _, url := range tier {
+			if url != "" {
+				urls = append(urls, url)
+			}
+		}
+		if len(urls) > 0 {
+			tiers = append(tiers, urls)
+		}
+	}
+	if len(tiers) == 0 && meta.TorrentFileInfo.Announce != "" {
+		tiers = [][]string{{meta.TorrentFileInfo.Announce}}
+	}
+	return tiers
+}
+
+func NewTrackerTiers(meta *TorrentFileMeta) *TrackerTiers {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	tiers := meta.Trackers()
+	for _, tier := range tiers {
+		random.Shuffle(len(tier), func(i, j int) { tier[i], tier[j] = tier[j], tier[i] })
+	}
+	return &TrackerTiers{tiers: tiers}
+}
+
+// Tiers returns a copy of the current tier order.
+func (trackers *TrackerTiers) Tiers() [][]string {
+	trackers.mu.Lock()
+	defer trackers.mu.Unlock()
+	tiers := make([][]string, len(trackers.tiers))
+	for i, tier := range trackers.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+	return tiers
+}
+
+// Announce calls announce for each tracker in tier order until one succeeds.
+func (trackers *TrackerTiers) Announce(announce func(trackerURL string) (TrackerResponse, error)) (TrackerResponse, string, error) {
+	var failures []string
+	for tierIndex, tier := range trackers.Tiers() {
+		for _, trackerURL := range tier {
+			resp, err := announce(trackerURL)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %s", trackerURL, err))
+				continue
+			}
+			trackers.promote(tierIndex, trackerURL)
+			return resp, trackerURL, nil
+		}
+	}
+	if len(failures) == 0 {
+		return TrackerResponse{}, "", fmt.Errorf("torrent has no trackers")
+	}
+	return TrackerResponse{}, "", fmt.Errorf("all trackers failed: %s", strings.Join(failures, "; "))
+}
+
+func (trackers *TrackerTiers) promote(tierIndex int, trackerURL string) {
+	trackers.mu.Lock()
+	defer trackers.mu.Unlock()
+	tier := trackers.tiers[tierIndex]
+	for i, url := range tier {
+		if url == trackerURL {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = trackerURL
+			return
+		}
+	}
+}
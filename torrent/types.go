@@ -1,28 +1,68 @@
 package torrent
 
-import "strconv"
+import (
+	"strconv"
+	"sync"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
 
 type TorrentFileInfo struct {
-	Length      int    `bencode:"length"`
-	Name        string `bencode:"name"`
-	PieceLength int    `bencode:"piece length"`
-	Pieces      string `bencode:"pieces"`
+	// Length is set for single-file torrents, Files for multi-file ones.
+	Length      int64      `bencode:"length,omitempty"`
+	Files       []FileInfo `bencode:"files,omitempty"`
+	Name        string     `bencode:"name"`
+	PieceLength int64      `bencode:"piece length"`
+	// Pieces is absent from pure v2 torrents.
+	Pieces      PieceHashes `bencode:"pieces,omitempty"`
+	Private     bool        `bencode:"private,omitempty"`
+	Source      string      `bencode:"source,omitempty"`
+	MetaVersion int64       `bencode:"meta version,omitempty"`
+	FileTree    *FileTree   `bencode:"file tree,omitempty"`
 }
 
 type TorrentFile struct {
-	Announce string
-	Info     TorrentFileInfo
-	InfoHash string
+	Announce     string     `bencode:"announce,omitempty"`
+	AnnounceList [][]string `bencode:"announce-list,omitempty"`
+	Comment      string     `bencode:"comment,omitempty"`
+	CreatedBy    string     `bencode:"created by,omitempty"`
+	CreationDate int64      `bencode:"creation date,omitempty"`
+	// URLList and HTTPSeeds hold the BEP 19 web seeds and BEP 17 HTTP seeds.
+	URLList   URLList         `bencode:"url-list,omitempty"`
+	HTTPSeeds []string        `bencode:"httpseeds,omitempty"`
+	Info      TorrentFileInfo `bencode:"-"`
+	InfoHash  string          `bencode:"-"`
+	// RawInfo holds the info dictionary exactly as it appeared in the file.
+	// It is what gets hashed and what is served over metadata exchange.
+	RawInfo bencode.RawMessage `bencode:"info"`
+	// PieceLayers maps a v2 file's pieces root to its concatenated piece
+	// layer hashes.
+	PieceLayers map[string]string `bencode:"piece layers,omitempty"`
 }
 
 type TorrentFileMeta struct {
 	TorrentFileInfo TorrentFile
-	InfoHashBytes   []byte
+	// InfoHashBytes is the hash used on the wire: SHA-1 of the info
+	// dictionary, or the truncated InfoHashV2 for pure v2 torrents.
+	InfoHashBytes []byte
+	// InfoHashV2 is the SHA-256 of the info dictionary for v2 and hybrid
+	// torrents.
+	InfoHashV2 []byte
+
+	// layout caches the files and piece spans once pieces are looked up;
+	// the info dictionary must not change after that.
+	layoutMu sync.Mutex
+	layout   *pieceLayout
 }
 
 type TrackerResponse struct {
-	Interval int    `bencode:"interval"`
-	Peers    string `bencode:"peers"`
+	FailureReason string        `bencode:"failure reason,omitempty"`
+	Interval      int           `bencode:"interval"`
+	MinInterval   int           `bencode:"min interval,omitempty"`
+	Complete      int           `bencode:"complete,omitempty"`
+	Incomplete    int           `bencode:"incomplete,omitempty"`
+	Peers         CompactPeers  `bencode:"peers"`
+	Peers6        CompactPeers6 `bencode:"peers6,omitempty"`
 }
 
 type TrackerClientParams struct {
@@ -33,16 +73,17 @@ type TrackerClientParams struct {
 	Downloaded string `url:"downloaded,omitempty"`
 	Left       string `url:"left,omitempty"`
 	Compact    string `url:"compact,omitempty"`
+	Event      string `url:"event,omitempty"`
 }
 
-func DefaultTrackerClientParams(infoHash string, fileLength int) *TrackerClientParams {
+func DefaultTrackerClientParams(infoHash string, fileLength int64) *TrackerClientParams {
 	return &TrackerClientParams{
 		InfoHash:   infoHash,
 		PeerId:     "00112233445566778899",
 		Port:       "6881",
 		Uploaded:   "0",
 		Downloaded: "0",
-		Left:       strconv.Itoa(fileLength),
+		Left:       strconv.FormatInt(fileLength, 10),
 		Compact:    "1",
 	}
 }
@@ -0,0 +1,287 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BEP 15 actions.
+const (
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+)
+
+const (
+	udpProtocolID = 0x41727101980
+	// udpConnectionIDTTL is how long a connection id may be used by the
+	// client after it was received.
+	udpConnectionIDTTL = time.Minute
+	// udpMaxScrapeHashes keeps a scrape request within one packet.
+	udpMaxScrapeHashes = 74
+)
+
+// UDPTracker talks to `udp://` trackers (BEP 15). Connection ids are cached
+// per tracker address, and requests are retransmitted after
+// Timeout * 2^n for n up to MaxRetransmits.
+type UDPTracker struct {
+	Timeout        time.Duration
+	MaxRetransmits int
+
+	mu          sync.Mutex
+	connections map[string]udpConnection
+	random      *rand.Rand
+	// key identifies us to trackers across IP changes, so it stays the same
+	// for the tracker's lifetime.
+	key uint32
+}
+
+type udpConnection struct {
+	id      uint64
+	expires time.Time
+}
+
+// NewUDPTracker follows the BEP 15 schedule of 15 * 2^n seconds but gives
+// up after 3 retransmissions rather than 8, which would stall failover to
+// the next tracker for an hour.
+func NewUDPTracker() *UDPTracker {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &UDPTracker{
+		Timeout:        15 * time.Second,
+		MaxRetransmits: 3,
+		connections:    make(map[string]udpConnection),
+		random:         random,
+		key:            random.Uint32(),
+	}
+}
+
+var defaultUDPTracker = NewUDPTracker()
+
+// udpEvents numbers the announce events; no event is 0.
+var udpEvents = map[string]uint32{
+	string(EventCompleted): 1,
+	string(EventStarted):   2,
+	string(EventStopped):   3,
+}
+
+var errUDPTimeout = errors.New("udp tracker timed out")
+
+// udpFailure is an error response (action 3) from the tracker.
+type udpFailure struct {
+	message string
+}
+
+func (failure *udpFailure) Error() string {
+	return "tracker failure: " + failure.message
+}
+
+func (tracker *UDPTracker) transactionID() uint32 {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	return tracker.random.Uint32()
+}
+
+func (tracker *UDPTracker) connectionID(address string) (uint64, bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	connection, ok := tracker.connections[address]
+	if !ok || time.Now().After(connection.expires) {
+		return 0, false
+	}
+	return connection.id, true
+}
+
+func (tracker *UDPTracker) setConnectionID(address string, id uint64) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.connections[address] = udpConnection{id: id, expires: time.Now().Add(udpConnectionIDTTL)}
+}
+
+func (tracker *UDPTracker) forget(address string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	delete(tracker.connections, address)
+}
+
+// request sends action with body to the tracker at address, connecting
+// first when there is no live connection id, and returns the response
+// after the action and transaction id.
+func (tracker *UDPTracker) request(address string, action uint32, body []byte) ([]byte, *net.UDPAddr, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	remote, _ := conn.RemoteAddr().(*net.UDPAddr)
+
+	reconnected := false
+	for n := 0; n <= tracker.MaxRetransmits; n++ {
+		timeout := tracker.Timeout << uint(n)
+		id, cached := tracker.connectionID(address)
+		if !cached {
+			response, err := tracker.roundTrip(conn, udpProtocolID, udpActionConnect, nil, timeout)
+			if err == errUDPTimeout {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(response) < 8 {
+				return nil, nil, fmt.Errorf("connect response of %d bytes is too short", len(response))
+			}
+			id = binary.BigEndian.Uint64(response)
+			tracker.setConnectionID(address, id)
+		}
+		response, err := tracker.roundTrip(conn, id, action, body, timeout)
+		if err == errUDPTimeout {
+			continue
+		}
+		var failure *udpFailure
+		if errors.As(err, &failure) && cached && !reconnected {
+			// the tracker may have expired the id before we did, so get a
+			// new one and try once more
+			tracker.forget(address)
+			reconnected = true
+			n--
+			continue
+		}
+		if err != nil {
+			tracker.forget(address)
+			return nil, nil, err
+		}
+		return response, remote, nil
+	}
+	return nil, nil, errUDPTimeout
+}
+
+// roundTrip sends one packet and waits up to timeout for the response with
+// the same transaction id, ignoring anything else.
+func (tracker *UDPTracker) roundTrip(conn net.Conn, connectionID uint64, action uint32, body []byte, timeout time.Duration) ([]byte, error) {
+	transactionID := tracker.transactionID()
+	var packet bytes.Buffer
+	binary.Write(&packet, binary.BigEndian, connectionID)
+	binary.Write(&packet, binary.BigEndian, action)
+	binary.Write(&packet, binary.BigEndian, transactionID)
+	packet.Write(body)
+	if _, err := conn.Write(packet.Bytes()); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, errUDPTimeout
+			}
+			return nil, err
+		}
+		if n < 8 || binary.BigEndian.Uint32(buf[4:8]) != transactionID {
+			continue
+		}
+		switch responseAction := binary.BigEndian.Uint32(buf[0:4]); responseAction {
+		case action:
+			return append([]byte(nil), buf[8:n]...), nil
+		case udpActionError:
+			return nil, &udpFailure{message: string(buf[8:n])}
+		default:
+			return nil, fmt.Errorf("tracker answered action %d with action %d", action, responseAction)
+		}
+	}
+}
+
+// Announce sends params to the tracker at address (host:port). Peers come
+// back in the address family of the tracker: Peers for IPv4, Peers6 for
+// IPv6.
+func (tracker *UDPTracker) Announce(address string, params *TrackerClientParams) (TrackerResponse, error) {
+	if len(params.InfoHash) != 20 || len(params.PeerId) != 20 {
+		return TrackerResponse{}, fmt.Errorf("info hash and peer id must be 20 bytes")
+	}
+	var numbers [4]int64
+	for i, value := range []string{params.Downloaded, params.Left, params.Uploaded, params.Port} {
+		if value == "" {
+			continue
+		}
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return TrackerResponse{}, fmt.Errorf("invalid announce parameter %q", value)
+		}
+		numbers[i] = number
+	}
+	if numbers[3] < 0 || numbers[3] > 65535 {
+		return TrackerResponse{}, fmt.Errorf("invalid announce port %d", numbers[3])
+	}
+	var body bytes.Buffer
+	body.WriteString(params.InfoHash)
+	body.WriteString(params.PeerId)
+	binary.Write(&body, binary.BigEndian, numbers[0]) // downloaded
+	binary.Write(&body, binary.BigEndian, numbers[1]) // left
+	binary.Write(&body, binary.BigEndian, numbers[2]) // uploaded
+	binary.Write(&body, binary.BigEndian, udpEvents[params.Event])
+	binary.Write(&body, binary.BigEndian, uint32(0))   // ip: the sender's
+	binary.Write(&body, binary.BigEndian, tracker.key) // key
+	binary.Write(&body, binary.BigEndian, int32(-1))   // num_want: default
+	binary.Write(&body, binary.BigEndian, uint16(numbers[3]))
+
+	response, remote, err := tracker.request(address, udpActionAnnounce, body.Bytes())
+	if err != nil {
+		return TrackerResponse{}, err
+	}
+	if len(response) < 12 {
+		return TrackerResponse{}, fmt.Errorf("announce response of %d bytes is too short", len(response))
+	}
+	trackerResp := TrackerResponse{
+		Interval:   int(binary.BigEndian.Uint32(response[0:4])),
+		Incomplete: int(binary.BigEndian.Uint32(response[4:8])),
+		Complete:   int(binary.BigEndian.Uint32(response[8:12])),
+	}
+	if remote != nil && remote.IP.To4() == nil {
+		peers, err := decodeCompactPeers(response[12:], net.IPv6len)
+		trackerResp.Peers6 = CompactPeers6(peers)
+		return trackerResp, err
+	}
+	trackerResp.Peers, err = decodeCompactPeers(response[12:], net.IPv4len)
+	return trackerResp, err
+}
+
+// Scrape asks the tracker at address for the swarm statistics of up to 74
+// info hashes, returned in the same order.
+func (tracker *UDPTracker) Scrape(address string, infoHashes [][]byte) ([]ScrapeStats, error) {
+	if len(infoHashes) == 0 || len(infoHashes) > udpMaxScrapeHashes {
+		return nil, fmt.Errorf("can scrape 1 to %d info hashes at once, got %d", udpMaxScrapeHashes, len(infoHashes))
+	}
+	var body []byte
+	for _, infoHash := range infoHashes {
+		if len(infoHash) != 20 {
+			return nil, fmt.Errorf("info hash %x is not 20 bytes", infoHash)
+		}
+		body = append(body, infoHash...)
+	}
+	response, _, err := tracker.request(address, udpActionScrape, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 12*len(infoHashes) {
+		return nil, fmt.Errorf("scrape response of %d bytes is too short for %d hashes", len(response), len(infoHashes))
+	}
+	stats := make([]ScrapeStats, len(infoHashes))
+	for i := range stats {
+		entry := response[12*i:]
+		stats[i] = ScrapeStats{
+			Complete:   int(binary.BigEndian.Uint32(entry[0:4])),
+			Downloaded: int(binary.BigEndian.Uint32(entry[4:8])),
+			Incomplete: int(binary.BigEndian.Uint32(entry[8:12])),
+		}
+	}
+	return stats, nil
+}
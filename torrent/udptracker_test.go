@@ -0,0 +1,124 @@
+package torrent
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUDPTracker answers connects with a new connection id each time and
+// announces only for the latest id, recording the key and port sent.
+type fakeUDPTracker struct {
+	conn *net.UDPConn
+
+	mu       sync.Mutex
+	id       uint64
+	connects int
+	keys     []uint32
+	ports    []uint16
+}
+
+func newFakeUDPTracker(t *testing.T) *fakeUDPTracker {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := &fakeUDPTracker{conn: conn}
+	go tracker.serve()
+	return tracker
+}
+
+func (tracker *fakeUDPTracker) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, remote, err := tracker.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		packet := buf[:n]
+		id := binary.BigEndian.Uint64(packet[0:8])
+		action := binary.BigEndian.Uint32(packet[8:12])
+		response := make([]byte, 8)
+		binary.BigEndian.PutUint32(response[0:4], action)
+		copy(response[4:8], packet[12:16])
+
+		tracker.mu.Lock()
+		switch {
+		case action == udpActionConnect:
+			tracker.connects++
+			tracker.id++
+			response = append(response, make([]byte, 8)...)
+			binary.BigEndian.PutUint64(response[8:], tracker.id)
+		case id != tracker.id:
+			binary.BigEndian.PutUint32(response[0:4], udpActionError)
+			response = append(response, "connection id expired"...)
+		default:
+			// key at 88, port at 96 of the announce packet
+			tracker.keys = append(tracker.keys, binary.BigEndian.Uint32(packet[88:92]))
+			tracker.ports = append(tracker.ports, binary.BigEndian.Uint16(packet[96:98]))
+			response = append(response, make([]byte, 12)...)
+			binary.BigEndian.PutUint32(response[8:12], 1800)
+		}
+		tracker.mu.Unlock()
+		tracker.conn.WriteToUDP(response, remote)
+	}
+}
+
+// expire makes the tracker forget the id it handed out.
+func (tracker *fakeUDPTracker) expire() {
+	tracker.mu.Lock()
+	tracker.id++
+	tracker.mu.Unlock()
+}
+
+func udpAnnounceParams(port string) *TrackerClientParams {
+	return &TrackerClientParams{
+		InfoHash: strings.Repeat("i", 20),
+		PeerId:   strings.Repeat("p", 20),
+		Port:     port,
+		Left:     "100",
+	}
+}
+
+func TestUDPTrackerAnnounce(t *testing.T) {
+	server := newFakeUDPTracker(t)
+	defer server.conn.Close()
+	address := server.conn.LocalAddr().String()
+	tracker := NewUDPTracker()
+	tracker.Timeout = time.Second
+
+	for i := 0; i < 2; i++ {
+		if _, err := tracker.Announce(address, udpAnnounceParams("6881")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the tracker drops our id before we do: we reconnect and retry
+	server.expire()
+	if _, err := tracker.Announce(address, udpAnnounceParams("6881")); err != nil {
+		t.Fatalf("announce after the id expired: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.connects != 2 {
+		t.Errorf("connected %d times, want 2", server.connects)
+	}
+	if len(server.keys) != 3 || server.keys[0] != server.keys[1] || server.keys[1] != server.keys[2] {
+		t.Errorf("keys %v, want the same key for every announce", server.keys)
+	}
+	if server.ports[0] != 6881 {
+		t.Errorf("port %d, want 6881", server.ports[0])
+	}
+}
+
+func TestUDPTrackerAnnouncePort(t *testing.T) {
+	for _, port := range []string{"-1", "65536", "70000"} {
+		if _, err := NewUDPTracker().Announce("127.0.0.1:1", udpAnnounceParams(port)); err == nil {
+			t.Errorf("port %s: announce succeeded", port)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+const (
+	// MerkleBlockSize is the size of the leaves of a v2 file's merkle tree.
+	MerkleBlockSize = 16 * 1024
+	MerkleHashSize  = sha256.Size
+)
+
+// FileTreeFile is the dictionary under the "" key of a v2 `file tree` leaf.
+// Empty files have no pieces root.
+type FileTreeFile struct {
+	Length      int64    `bencode:"length"`
+	PiecesRoot  []byte   `bencode:"pieces root,omitempty"`
+	Attr        string   `bencode:"attr,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+}
+
+// FileTree is a node of the v2 `file tree` (BEP 52). A file is a node whose
+// only key is "", a directory maps names to child nodes.
+type FileTree struct {
+	File     *FileTreeFile
+	Children map[string]*FileTree
+}
+
+func (tree *FileTree) UnmarshalBencode(data []byte) error {
+	var dict map[string]bencode.RawMessage
+	if err := bencode.Unmarshal(data, &dict); err != nil {
+		return err
+	}
+	if raw, ok := dict[""]; ok {
+		if len(dict) != 1 {
+			return fmt.Errorf("file tree node has both a file and children")
+		}
+		tree.File = &FileTreeFile{}
+		return bencode.Unmarshal(raw, tree.File)
+	}
+	tree.Children = make(map[string]*FileTree, len(dict))
+	for name, raw := range dict {
+		child := &FileTree{}
+		if err := child.UnmarshalBencode(raw); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		tree.Children[name] = child
+	}
+	return nil
+}
+
+func (tree *FileTree) MarshalBencode() ([]byte, error) {
+	if tree.File != nil {
+		return bencode.Marshal(map[string]*FileTreeFile{"": tree.File})
+	}
+	return bencode.Marshal(tree.Children)
+}
+
+// walk calls fn for every file in bencode (byte-wise sorted) order.
+func (tree *FileTree) walk(path []string, fn func(path []string, file *FileTreeFile)) {
+	if tree.File != nil {
+		fn(path, tree.File)
+		return
+	}
+	names := make([]string, 0, len(tree.Children))
+	for name := range tree.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tree.Children[name].walk(append(path[:len(path):len(path)], name), fn)
+	}
+}
+
+// IsV2 reports whether the info dictionary carries v2 metadata.
+func (meta *TorrentFileMeta) IsV2() bool {
+	return meta.TorrentFileInfo.Info.MetaVersion == 2
+}
+
+// IsV1 reports whether the info dictionary carries v1 metadata, which v2
+// torrents only do when they are hybrid.
+func (meta *TorrentFileMeta) IsV1() bool {
+	info := meta.TorrentFileInfo.Info
+	return !meta.IsV2() || len(info.Pieces) > 0 || len(info.Files) > 0 || info.Length > 0
+}
+
+func (meta *TorrentFileMeta) IsHybrid() bool {
+	return meta.IsV1() && meta.IsV2()
+}
+
+// Version is "v1", "v2" or "hybrid".
+func (meta *TorrentFileMeta) Version() string {
+	switch {
+	case meta.IsHybrid():
+		return "hybrid"
+	case meta.IsV2():
+		return "v2"
+	}
+	return "v1"
+}
+
+// isV2SingleFile is true when the file tree holds one file directly under
+// the root, which is how v2 lays out a single-file torrent.
+func (meta *TorrentFileMeta) isV2SingleFile() bool {
+	tree := meta.TorrentFileInfo.Info.FileTree
+	if tree == nil || len(tree.Children) != 1 {
+		return false
+	}
+	for _, child := range tree.Children {
+		return child.File != nil
+	}
+	return false
+}
+
+// filesV2 lays out the file tree in the same form as Files. Offsets are
+// cumulative so storage can address the files, but v2 pieces never span
+// files: use V2PieceCount and friends for piece arithmetic.
+func (meta *TorrentFileMeta) filesV2() []FileEntry {
+	info := meta.TorrentFileInfo.Info
+	if info.FileTree == nil {
+		return nil
+	}
+	var prefix []string
+	if !meta.isV2SingleFile() {
+		prefix = []string{info.Name}
+	}
+	var entries []FileEntry
+	var offset int64
+	info.FileTree.walk(prefix, func(path []string, file *FileTreeFile) {
+		entries = append(entries, FileEntry{
+			Path:        path,
+			Length:      file.Length,
+			Offset:      offset,
+			PiecesRoot:  file.PiecesRoot,
+			Attr:        file.Attr,
+			SymlinkPath: file.SymlinkPath,
+		})
+		offset += file.Length
+	})
+	return entries
+}
+
+// V2PieceCount is the number of pieces of a v2 file. Each file starts on a
+// piece boundary.
+func (meta *TorrentFileMeta) V2PieceCount(file FileEntry) int {
+	return int((file.Length + meta.PieceLength() - 1) / meta.PieceLength())
+}
+
+// PieceLayer returns the piece layer hashes of file from `piece layers`, or
+// nil for files of at most one piece, whose pieces root covers them directly.
+func (meta *TorrentFileMeta) PieceLayer(file FileEntry) [][MerkleHashSize]byte {
+	raw, ok := meta.TorrentFileInfo.PieceLayers[string(file.PiecesRoot)]
+	if !ok || len(raw)%MerkleHashSize != 0 {
+		return nil
+	}
+	layer := make([][MerkleHashSize]byte, len(raw)/MerkleHashSize)
+	for i := range layer {
+		copy(layer[i][:], raw[i*MerkleHashSize:])
+	}
+	return layer
+}
+
+// pieceLayerHash returns hash index of file's piece layer without decoding
+// the whole layer.
+func (meta *TorrentFileMeta) pieceLayerHash(file FileEntry, index int) ([MerkleHashSize]byte, bool) {
+	var hash [MerkleHashSize]byte
+	raw := meta.TorrentFileInfo.PieceLayers[string(file.PiecesRoot)]
+	if len(raw) != meta.V2PieceCount(file)*MerkleHashSize || index < 0 || index >= meta.V2PieceCount(file) {
+		return hash, false
+	}
+	copy(hash[:], raw[index*MerkleHashSize:])
+	return hash, true
+}
+
+// VerifyV2Piece checks piece index of file against its merkle tree: the
+// piece layer for multi-piece files, the pieces root otherwise.
+func (meta *TorrentFileMeta) VerifyV2Piece(file FileEntry, index int, data []byte) error {
+	if index < 0 || index >= meta.V2PieceCount(file) {
+		return fmt.Errorf("piece index %d out of range [0, %d)", index, meta.V2PieceCount(file))
+	}
+	if file.Length <= meta.PieceLength() {
+		leaves := blockHashes(data)
+		root := merkleRoot(leaves, nextPowerOfTwo(len(leaves)), [MerkleHashSize]byte{})
+		if !bytes.Equal(root[:], file.PiecesRoot) {
+			return fmt.Errorf("hash mismatch")
+		}
+		return nil
+	}
+	want, ok := meta.pieceLayerHash(file, index)
+	if !ok {
+		return fmt.Errorf("missing piece layer for %s", file)
+	}
+	if hash := merkleRoot(blockHashes(data), int(meta.PieceLength()/MerkleBlockSize), [MerkleHashSize]byte{}); hash != want {
+		return fmt.Errorf("hash mismatch")
+	}
+	return nil
+}
+
+// checkV2 validates the v2 parts of the metainfo: piece length, pieces
+// roots and any piece layers present, which must hash up to their root.
+// Piece layers sit outside the info dictionary, so metadata fetched from
+// peers legitimately lacks them.
+func (meta *TorrentFileMeta) checkV2() error {
+	info := meta.TorrentFileInfo.Info
+	if info.FileTree == nil {
+		return fmt.Errorf("meta version 2 without a file tree")
+	}
+	if info.PieceLength < MerkleBlockSize || info.PieceLength&(info.PieceLength-1) != 0 {
+		return fmt.Errorf("v2 piece length %d is not a power of two of at least %d", info.PieceLength, MerkleBlockSize)
+	}
+	var total int64
+	for _, file := range meta.filesV2() {
+		if file.Length < 0 {
+			return fmt.Errorf("%s: negative length %d", file, file.Length)
+		}
+		if file.Length > math.MaxInt64-total {
+			return fmt.Errorf("%s: total length overflows", file)
+		}
+		total += file.Length
+		if file.Length == 0 {
+			continue
+		}
+		if len(file.PiecesRoot) != MerkleHashSize {
+			return fmt.Errorf("%s: pieces root has %d bytes", file, len(file.PiecesRoot))
+		}
+		if file.Length <= info.PieceLength {
+			continue
+		}
+		raw, ok := meta.TorrentFileInfo.PieceLayers[string(file.PiecesRoot)]
+		if !ok {
+			continue
+		}
+		if len(raw) != meta.V2PieceCount(file)*MerkleHashSize {
+			return fmt.Errorf("%s: piece layer has %d bytes, want %d", file, len(raw), meta.V2PieceCount(file)*MerkleHashSize)
+		}
+		layer := meta.PieceLayer(file)
+		pad := zeroSubtreeRoot(int(info.PieceLength / MerkleBlockSize))
+		if root := merkleRoot(layer, nextPowerOfTwo(len(layer)), pad); !bytes.Equal(root[:], file.PiecesRoot) {
+			return fmt.Errorf("%s: piece layer does not match pieces root", file)
+		}
+	}
+	return nil
+}
+
+func infoHashV2(rawInfo []byte) []byte {
+	hash := sha256.Sum256(rawInfo)
+	return hash[:]
+}
+
+// blockHashes hashes data in MerkleBlockSize blocks; the last may be short.
+func blockHashes(data []byte) [][MerkleHashSize]byte {
+	var hashes [][MerkleHashSize]byte
+	for offset := 0; offset < len(data); offset += MerkleBlockSize {
+		end := offset + MerkleBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		hashes = append(hashes, sha256.Sum256(data[offset:end]))
+	}
+	return hashes
+}
+
+// merkleRoot builds a tree of width leaves (a power of two) over hashes,
+// filling the missing leaves with pad, and returns its root.
+func merkleRoot(hashes [][MerkleHashSize]byte, width int, pad [MerkleHashSize]byte) [MerkleHashSize]byte {
+	layer := make([][MerkleHashSize]byte, width)
+	copy(layer, hashes)
+	for i := len(hashes); i < width; i++ {
+		layer[i] = pad
+	}
+	for len(layer) > 1 {
+		for i := 0; i < len(layer)/2; i++ {
+			layer[i] = sha256.Sum256(append(layer[2*i][:], layer[2*i+1][:]...))
+		}
+		layer = layer[:len(layer)/2]
+	}
+	if len(layer) == 0 {
+		return pad
+	}
+	return layer[0]
+}
+
+// zeroSubtreeRoot is the root of a subtree of width zero leaves: the value
+// that pads a piece layer beyond the end of a file.
+func zeroSubtreeRoot(width int) [MerkleHashSize]byte {
+	return merkleRoot(nil, width, [MerkleHashSize]byte{})
+}
+
+func nextPowerOfTwo(n int) int {
+	power := 1
+	for power < n {
+		power *= 2
+	}
+	return power
+}
@@ -0,0 +1,155 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type PieceStatus struct {
+	Index int    `json:"index"`
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+type FileStatus struct {
+	Path        string `json:"path"`
+	Length      int64  `json:"length"`
+	Pieces      int    `json:"pieces"`
+	ValidPieces int    `json:"valid_pieces"`
+	Complete    bool   `json:"complete"`
+}
+
+type VerifyReport struct {
+	InfoHash    string        `json:"info_hash"`
+	NumPieces   int           `json:"num_pieces"`
+	ValidPieces int           `json:"valid_pieces"`
+	Complete    bool          `json:"complete"`
+	Bitfield    string        `json:"bitfield"`
+	Pieces      []PieceStatus `json:"pieces"`
+	Files       []FileStatus  `json:"files"`
+}
+
+// VerifyStorage checks every piece in storage against the piece hashes, or
+// against the merkle trees for pure v2 torrents. Unreadable data (missing or
+// short files) marks pieces invalid rather than failing the whole run.
+func VerifyStorage(meta *TorrentFileMeta, storage Storage, workers int) (*VerifyReport, error) {
+	layout, err := meta.pieceLayout()
+	if err != nil {
+		return nil, err
+	}
+	spans, files := layout.spans, layout.files
+	report := &VerifyReport{
+		InfoHash:  fmt.Sprintf("%x", meta.InfoHashBytes),
+		NumPieces: len(spans),
+		Pieces:    make([]PieceStatus, len(spans)),
+	}
+	bitfield := NewBitfield(len(spans))
+	var mu sync.Mutex
+	err = readPieces(meta, storage, workers, func(index int, piece []byte, err error) error {
+		status := PieceStatus{Index: index}
+		if err == nil {
+			err = meta.verifySpan(files, spans[index], index, piece)
+		}
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Valid = true
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		report.Pieces[index] = status
+		if status.Valid {
+			bitfield.Set(index)
+			report.ValidPieces++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Bitfield = bitfield.String()
+	report.Complete = report.ValidPieces == report.NumPieces
+
+	for i, file := range files {
+		if file.IsPadding() {
+			continue
+		}
+		status := FileStatus{Path: file.String(), Length: file.Length}
+		if !meta.IsV1() {
+			for index, span := range spans {
+				if span.file == i {
+					status.Pieces++
+					if bitfield.Has(index) {
+						status.ValidPieces++
+					}
+				}
+			}
+		} else if file.Length > 0 {
+			first := int(file.Offset / meta.PieceLength())
+			last := int((file.Offset + file.Length - 1) / meta.PieceLength())
+			for index := first; index <= last && index < meta.NumPieces(); index++ {
+				status.Pieces++
+				if bitfield.Has(index) {
+					status.ValidPieces++
+				}
+			}
+		}
+		status.Complete = status.ValidPieces == status.Pieces
+		report.Files = append(report.Files, status)
+	}
+	return report, nil
+}
+
+// VerifyPiece checks piece index, as numbered by pieceSpans, against the
+// piece hashes or, for pure v2 torrents, the merkle trees.
+func (meta *TorrentFileMeta) VerifyPiece(index int, piece []byte) error {
+	layout, err := meta.pieceLayout()
+	if err != nil {
+		return err
+	}
+	span, err := layout.span(index)
+	if err != nil {
+		return err
+	}
+	return meta.verifySpan(layout.files, span, index, piece)
+}
+
+func (meta *TorrentFileMeta) verifySpan(files []FileEntry, span pieceSpan, index int, piece []byte) error {
+	if int64(len(piece)) != span.length {
+		return fmt.Errorf("piece has %d bytes, want %d", len(piece), span.length)
+	}
+	if !meta.IsV1() {
+		return meta.VerifyV2Piece(files[span.file], span.fileIndex, piece)
+	}
+	if hash := sha1.Sum(piece); !bytes.Equal(hash[:], meta.PieceHash(index)) {
+		return fmt.Errorf("hash mismatch")
+	}
+	return nil
+}
+
+// String renders failed pieces, per-file completion and a summary.
+func (report *VerifyReport) String() string {
+	var lines []string
+	for _, piece := range report.Pieces {
+		if !piece.Valid {
+			lines = append(lines, fmt.Sprintf("piece %d: FAILED (%s)", piece.Index, piece.Error))
+		}
+	}
+	for _, file := range report.Files {
+		lines = append(lines, fmt.Sprintf("file %s: %s (%d/%d pieces)", file.Path, percent(file.ValidPieces, file.Pieces), file.ValidPieces, file.Pieces))
+	}
+	lines = append(lines,
+		fmt.Sprintf("Bitfield: %s", report.Bitfield),
+		fmt.Sprintf("Summary: %d/%d pieces valid (%s)", report.ValidPieces, report.NumPieces, percent(report.ValidPieces, report.NumPieces)))
+	return strings.Join(lines, "\n")
+}
+
+func percent(part, whole int) string {
+	if whole == 0 {
+		return "100.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(whole))
+}
@@ -0,0 +1,167 @@
+package torrent
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+// URLList is the BEP 19 `url-list`: a single URL string or a list of them.
+type URLList []string
+
+func (urls *URLList) UnmarshalBencode(data []byte) error {
+	if len(data) > 0 && data[0] == 'l' {
+		var list []string
+		if err := bencode.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		*urls = list
+		return nil
+	}
+	single, err := bencode.UnmarshalString(data)
+	if err != nil {
+		return err
+	}
+	*urls = nil
+	if len(single) > 0 {
+		*urls = URLList{string(single)}
+	}
+	return nil
+}
+
+func (urls URLList) MarshalBencode() ([]byte, error) {
+	return bencode.Marshal([]string(urls))
+}
+
+// webSeedHTTPClient is used by web seeds created without a client.
+var webSeedHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// WebSeed is a BEP 19 HTTP/FTP-style web seed: a plain web server holding
+// the torrent's files, from which pieces are fetched with Range requests.
+type WebSeed struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebSeed returns a web seed for rawURL. httpClient may be nil.
+func NewWebSeed(rawURL string, httpClient *http.Client) *WebSeed {
+	if httpClient == nil {
+		httpClient = webSeedHTTPClient
+	}
+	return &WebSeed{URL: rawURL, Client: httpClient}
+}
+
+// WebSeeds returns the torrent's HTTP web seeds. Other schemes are skipped.
+func (meta *TorrentFileMeta) WebSeeds(httpClient *http.Client) []PiecePeer {
+	var seeds []PiecePeer
+	for _, rawURL := range meta.TorrentFileInfo.URLList {
+		if parsed, err := url.Parse(rawURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			fmt.Printf("Skipping web seed %q\n", rawURL)
+			continue
+		}
+		seeds = append(seeds, NewWebSeed(rawURL, httpClient))
+	}
+	return seeds
+}
+
+func (seed *WebSeed) String() string {
+	return seed.URL
+}
+
+// fileURL maps a file onto the seed: a single-file torrent is the URL
+// itself, or URL+name when it ends in a slash; multi-file torrents live
+// under URL/name/path.
+func (seed *WebSeed) fileURL(meta *TorrentFileMeta, file FileEntry) string {
+	base := seed.URL
+	if !meta.IsMultiFile() && !strings.HasSuffix(base, "/") {
+		return base
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	escaped := make([]string, len(file.Path))
+	for i, component := range file.Path {
+		escaped[i] = url.PathEscape(component)
+	}
+	return base + strings.Join(escaped, "/")
+}
+
+// FetchPiece downloads piece index with one Range request per file it spans
+// and checks it against the piece hashes.
+func (seed *WebSeed) FetchPiece(meta *TorrentFileMeta, index int) ([]byte, error) {
+	layout, err := meta.pieceLayout()
+	if err != nil {
+		return nil, err
+	}
+	span, err := layout.span(index)
+	if err != nil {
+		return nil, err
+	}
+	piece := make([]byte, span.length)
+	end := span.offset + span.length
+	// files are in offset order, so start at the first one reaching the piece
+	first := sort.Search(len(layout.files), func(i int) bool {
+		return layout.files[i].Offset+layout.files[i].Length > span.offset
+	})
+	for _, file := range layout.files[first:] {
+		if file.Offset >= end {
+			break
+		}
+		fileEnd := file.Offset + file.Length
+		if file.Length == 0 {
+			continue
+		}
+		lo, hi := span.offset, end
+		if lo < file.Offset {
+			lo = file.Offset
+		}
+		if hi > fileEnd {
+			hi = fileEnd
+		}
+		if file.IsPadding() {
+			// already zero
+			continue
+		}
+		if err := seed.fetchRange(seed.fileURL(meta, file), lo-file.Offset, piece[lo-span.offset:hi-span.offset]); err != nil {
+			return nil, err
+		}
+	}
+	if err := meta.verifySpan(layout.files, span, index, piece); err != nil {
+		return nil, fmt.Errorf("web seed %s: piece %d: %w", seed.URL, index, err)
+	}
+	return piece, nil
+}
+
+// fetchRange fills buf from fileURL starting at offset. A server that
+// ignores Range and answers 200 is tolerated by skipping to offset.
+func (seed *WebSeed) fetchRange(fileURL string, offset int64, buf []byte) error {
+	req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(len(buf))-1))
+	res, err := seed.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if _, err := io.CopyN(io.Discard, res.Body, offset); err != nil {
+			return fmt.Errorf("%s: %w", fileURL, err)
+		}
+	default:
+		return fmt.Errorf("%s: unexpected status %s", fileURL, res.Status)
+	}
+	if _, err := io.ReadFull(res.Body, buf); err != nil {
+		return fmt.Errorf("%s: %w", fileURL, err)
+	}
+	return nil
+}
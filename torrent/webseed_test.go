@@ -0,0 +1,199 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const webSeedPieceLength = 16384
+
+type webSeedFile struct {
+	path   []string
+	length int
+	attr   string
+}
+
+// webSeedTorrent builds a torrent named name over files with random
+// content, writes the non-padding files under root/name (a single file is
+// root/name itself) and returns the metainfo and the content in piece order.
+func webSeedTorrent(t *testing.T, root, name string, files []webSeedFile) (*TorrentFileMeta, []byte) {
+	t.Helper()
+	random := rand.New(rand.NewSource(int64(len(files))))
+	var content []byte
+	var fileList []interface{}
+	for _, file := range files {
+		data := make([]byte, file.length)
+		if file.attr == "" {
+			random.Read(data)
+			path := filepath.Join(append([]string{root, name}, file.path...)...)
+			if len(files) == 1 {
+				path = filepath.Join(root, name)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		content = append(content, data...)
+		entry := map[string]interface{}{"length": file.length, "path": file.path}
+		if file.attr != "" {
+			entry["attr"] = file.attr
+		}
+		fileList = append(fileList, entry)
+	}
+	var pieces []byte
+	for offset := 0; offset < len(content); offset += webSeedPieceLength {
+		end := offset + webSeedPieceLength
+		if end > len(content) {
+			end = len(content)
+		}
+		hash := sha1.Sum(content[offset:end])
+		pieces = append(pieces, hash[:]...)
+	}
+	info := map[string]interface{}{
+		"name":         name,
+		"piece length": webSeedPieceLength,
+		"pieces":       string(pieces),
+	}
+	if len(files) == 1 {
+		info["length"] = files[0].length
+	} else {
+		info["files"] = fileList
+	}
+	data, err := Marshal(map[string]interface{}{"announce": "http://tracker.example/announce", "info": info})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ParseTorrent(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta, content
+}
+
+// fetchAll fetches every piece from seed and compares it with content.
+func fetchAll(t *testing.T, seed *WebSeed, meta *TorrentFileMeta, content []byte) {
+	t.Helper()
+	for index := 0; index < meta.NumPieces(); index++ {
+		piece, err := seed.FetchPiece(meta, index)
+		if err != nil {
+			t.Fatalf("%s: piece %d: %v", seed, index, err)
+		}
+		want := content[meta.PieceOffset(index) : meta.PieceOffset(index)+meta.PieceSize(index)]
+		if !bytes.Equal(piece, want) {
+			t.Fatalf("%s: piece %d has the wrong content", seed, index)
+		}
+	}
+}
+
+func TestWebSeedSingleFile(t *testing.T) {
+	root := t.TempDir()
+	meta, content := webSeedTorrent(t, root, "file.bin", []webSeedFile{{path: []string{"file.bin"}, length: 40000}})
+	server := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer server.Close()
+
+	// the URL names the file itself, or its directory when it ends in a slash
+	fetchAll(t, NewWebSeed(server.URL+"/file.bin", server.Client()), meta, content)
+	fetchAll(t, NewWebSeed(server.URL+"/", server.Client()), meta, content)
+}
+
+func TestWebSeedMultiFile(t *testing.T) {
+	root := t.TempDir()
+	// piece 0 spans a and sub/b
+	meta, content := webSeedTorrent(t, root, "dir", []webSeedFile{
+		{path: []string{"a"}, length: 10000},
+		{path: []string{"sub", "b c"}, length: 30000},
+	})
+	server := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer server.Close()
+
+	fetchAll(t, NewWebSeed(server.URL, server.Client()), meta, content)
+	fetchAll(t, NewWebSeed(server.URL+"/", server.Client()), meta, content)
+}
+
+func TestWebSeedPadding(t *testing.T) {
+	root := t.TempDir()
+	meta, content := webSeedTorrent(t, root, "dir", []webSeedFile{
+		{path: []string{"a"}, length: 10000},
+		{path: []string{".pad", "6384"}, length: 6384, attr: "p"},
+		{path: []string{"b"}, length: 20000},
+	})
+	var requested []string
+	fileServer := http.FileServer(http.Dir(root))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fetchAll(t, NewWebSeed(server.URL, server.Client()), meta, content)
+	for _, path := range requested {
+		if path == "/dir/.pad/6384" {
+			t.Errorf("padding file was requested from the web seed")
+		}
+	}
+}
+
+func TestWebSeedBadResponse(t *testing.T) {
+	root := t.TempDir()
+	meta, content := webSeedTorrent(t, root, "file.bin", []webSeedFile{{path: []string{"file.bin"}, length: 40000}})
+	tests := []struct {
+		name   string
+		status int
+		body   []byte
+	}{
+		// a 200 reply carries the whole file and the seed skips to the range
+		{"short", http.StatusOK, content[:100]},
+		{"corrupt", http.StatusOK, make([]byte, len(content))},
+		{"short range", http.StatusPartialContent, content[webSeedPieceLength : webSeedPieceLength+100]},
+	}
+	for _, test := range tests {
+		test := test
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write(test.body)
+		}))
+		if _, err := NewWebSeed(server.URL+"/file.bin", server.Client()).FetchPiece(meta, 1); err == nil {
+			t.Errorf("%s response: FetchPiece succeeded", test.name)
+		}
+		server.Close()
+	}
+}
+
+func TestDownloadPiecesDropsDeadPeer(t *testing.T) {
+	root := t.TempDir()
+	meta, content := webSeedTorrent(t, root, "file.bin", []webSeedFile{{path: []string{"file.bin"}, length: 40000}})
+	server := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer server.Close()
+	// nothing listens on a closed listener's address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	client := NewClient(meta, &Config{PeerId: "-XX0000-000000000000"})
+	peers := NewPeerList(client.TCPPeer(listener.Addr().String()))
+	var counts []int
+	peers.OnChange = func(n int) { counts = append(counts, n) }
+	storage := &memStorage{data: make([]byte, len(content))}
+	seeds := []PiecePeer{NewWebSeed(server.URL+"/file.bin", server.Client())}
+	if err := DownloadPieces(meta, peers, seeds, storage); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storage.data, content) {
+		t.Error("downloaded content differs")
+	}
+	if len(counts) != 1 || counts[0] != 0 {
+		t.Errorf("peer counts %v, want the dead peer dropped once", counts)
+	}
+}